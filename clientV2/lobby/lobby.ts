@@ -8,6 +8,14 @@ const host = "10.0.0.100"
 const urlSearchParams = new URLSearchParams(window.location.search)
 const gameCode = urlSearchParams?.get("gameCode")?.toUpperCase()
 const playerName = urlSearchParams.get("name")
+// Optional map file to play on; the server generates a map without one
+const mapName = urlSearchParams.get("map")
+// Players on the same nonzero team are allies; 0 plays alone
+const team = Number(urlSearchParams.get("team") ?? 0)
+// Spectators watch the game without playing
+const spectate = urlSearchParams.get("spectate") === "true"
+// Optional number of seconds spectators watch behind live play
+const spectatorDelay = Number(urlSearchParams.get("spectatorDelay") ?? 0)
 if (gameIdLabel) gameIdLabel.innerText = `Code: ${gameCode}`
 
 console.log(gameIdLabel)
@@ -18,14 +26,25 @@ console.log(playerName)
 // Render Player List
 let playerNames = ["Steven", "Jeff", "Jordan", "Mike"]
 
+// The server hands out a session token on joining; presenting it again
+// keeps our place in the lobby and our seat in the game across reloads
+const token = sessionStorage.getItem("sessionToken") ?? ""
+
 // Websocket
 const ws = new WebSocket(
-	`ws://${host}:8080/join?gameCode=${gameCode}&name=${playerName}`
+	`ws://${host}:8080/join?gameCode=${gameCode}&name=${playerName}&team=${team}&spectate=${spectate}&token=${token}`
 )
 
 ws.addEventListener("message", (event) => {
-	// data can be {names: string[]} or {start: bool; portNumber: number}
+	// data can be {token: string}, {names: string[]; teams: number[]; spectators: boolean[]}, {start: bool; portNumber: number; map: string; token: string} or {error: string}
 	const data = JSON.parse(event.data)
+	if (data.token) {
+		sessionStorage.setItem("sessionToken", data.token)
+	}
+	// The game couldn't start, say because the map has no room for everyone
+	if (data.error) {
+		alert(data.error)
+	}
 	if (data.names) {
 		playerNames = data.names ?? []
 
@@ -33,9 +52,12 @@ ws.addEventListener("message", (event) => {
 			if (playerList.lastChild) playerList.removeChild(playerList.lastChild)
 		}
 
-		playerNames.forEach((element) => {
+		const teams: number[] = data.teams ?? []
+		const spectators: boolean[] = data.spectators ?? []
+		playerNames.forEach((element, i) => {
 			const playerText = document.createElement("p")
-			playerText.innerText = element
+			playerText.innerText = teams[i] ? `${element} (team ${teams[i]})` : element
+			if (spectators[i]) playerText.innerText = `${element} (spectating)`
 			playerList?.appendChild(playerText)
 		})
 	}
@@ -47,6 +69,10 @@ ws.addEventListener("message", (event) => {
 })
 
 startButton?.addEventListener("click", async () => {
-	const startMsg = JSON.stringify({ start: true })
+	const startMsg = JSON.stringify({
+		start: true,
+		...(mapName ? { map: mapName } : {}),
+		...(spectatorDelay ? { spectatorDelay } : {}),
+	})
 	ws.send(startMsg)
 })
@@ -11,6 +11,11 @@ InitScene()
 const urlSearchParams = new URLSearchParams(window.location.search)
 const port = urlSearchParams.get("portNumber")
 const host = "10.0.0.100"
+// Set in the lobby; it's what lets us back into our seat if the connection drops
+const token = sessionStorage.getItem("sessionToken") ?? ""
+const reconnectDelayMs = 1000
+// The server gives up a dropped seat after a minute
+const maxReconnectAttempts = 60
 
 const sleep = (ms: number) => {
 	return new Promise((resolve) => { setTimeout(resolve, ms) })
@@ -25,7 +30,9 @@ async function InitScene() {
 	scene.startAnimationLoop()
 
 	// const socket = new WebSocket("ws://10.0.0.43:8080/ws")
-	const socket = new WebSocket(`ws://${host}:8080/${port}`)
+	let socket: WebSocket
+	let gameOver = false
+	let reconnectAttempts = 0
 
 	window.addEventListener("keydown", (event) => {
 		if (event.key.toLowerCase() === "t") {
@@ -42,14 +49,6 @@ async function InitScene() {
 		}
 	})
 
-	socket.addEventListener("open", (event: Event) => {
-		console.log("Connected to server", event)
-	})
-
-	socket.addEventListener("error", function (event) {
-		console.error("Error connecting to server", event)
-	})
-
 	initializeControlButtons(scene)
 
 	const playerNumElem = document.getElementById("player-number")
@@ -111,17 +110,45 @@ async function InitScene() {
 		switch (message.messageType) {
 			case "playerNumber":
 				playerNumElem.innerText = `${message.data.playerNumber}`
+				reconnectAttempts = 0
+				break
+			case "gameState":
+				// Sent on every (re)connect so we start from the whole game
 				break
 			case "commandResponse":
 				break
+			case "gameOver":
+				gameOver = true
+				break
 			default:
 				console.log("Unknown message type", message.type)
 		}
 	}
 
-	socket.addEventListener("message", function (event) {
-		handleMessage(event)
-	})
+	// The server holds our seat for a while if the connection drops, so keep
+	// trying to get back in until the game is over
+	const connect = () => {
+		socket = new WebSocket(`ws://${host}:8080/${port}?token=${token}`)
+
+		socket.addEventListener("open", (event: Event) => {
+			console.log("Connected to server", event)
+		})
+
+		socket.addEventListener("error", function (event) {
+			console.error("Error connecting to server", event)
+		})
+
+		socket.addEventListener("message", function (event) {
+			handleMessage(event)
+		})
+
+		socket.addEventListener("close", () => {
+			if (gameOver || reconnectAttempts >= maxReconnectAttempts) return
+			reconnectAttempts++
+			setTimeout(connect, reconnectDelayMs)
+		})
+	}
+	connect()
 
 	while (1) {
 		game.gameLoop()
@@ -0,0 +1,288 @@
+// Simulate plays matches headless, as fast as they'll run, with AI or
+// scripted players in every seat, and reports how they went. With -runs it
+// plays many matches from consecutive seeds and sums them up, which is how
+// to see what a catalog change does to the balance without anyone playing:
+//
+//	go run ./cmd/simulate -players rush,boom -minutes 20
+//	go run ./cmd/simulate -players rush,boom -runs 1000 -seed 7 -catalog stronger-knights.json
+//
+// Each seat is one of the built-in strategies (boom, rush), idle, or
+// script:<file> for a JSON list of timed commands.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"hackcu2025/sim"
+)
+
+// A setup is everything that's the same from run to run.
+type setup struct {
+	catalog *sim.Catalog
+	mapDir  string
+	// The map to play on. Generated maps get each run's seed.
+	choice  sim.MapChoice
+	victory sim.VictoryConditions
+	names   []string
+	players []newPlayerFunc
+}
+
+// A run is one match played out.
+type run struct {
+	seed     int64
+	mapName  string
+	over     *sim.GameOver
+	rejected map[sim.PlayerID]int
+}
+
+func main() {
+	catalogPath := flag.String("catalog", "", "JSON file of unit and building definitions (defaults to the built-in catalog)")
+	mapDir := flag.String("maps", "", "directory of JSON map files (defaults to the built-in maps)")
+	mapName := flag.String("map", "", "map to play on (defaults to a map generated from each run's seed)")
+	symmetry := flag.String("symmetry", "", "symmetry of generated maps")
+	teams := flag.String("teams", "", "comma-separated team for each player, 0 for none")
+	victory := flag.String("victory", "townHalls", "comma-separated victory conditions: townHalls, units")
+	minutes := flag.Float64("minutes", 30, "minutes of game time before the match goes to whoever has the highest score")
+	players := flag.String("players", "rush,boom", "comma-separated player for each seat: boom, rush, idle or script:<file>")
+	runs := flag.Int("runs", 1, "number of matches to play")
+	seed := flag.Int64("seed", 1, "seed of the first match; later ones count up from it")
+	parallel := flag.Int("parallel", runtime.NumCPU(), "number of matches to play at once")
+	verbose := flag.Bool("v", false, "with -runs, print every match as well as the totals")
+	flag.Parse()
+
+	s, err := newSetup(*catalogPath, *mapDir, *mapName, *symmetry, *teams, *victory, *minutes, *players)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *runs < 1 || *parallel < 1 {
+		fmt.Fprintln(os.Stderr, "-runs and -parallel must be at least 1")
+		os.Exit(2)
+	}
+
+	results, err := s.playAll(*seed, *runs, *parallel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *runs == 1 {
+		s.printRun(results[0])
+		return
+	}
+	if *verbose {
+		for _, r := range results {
+			fmt.Printf("seed %v on %v: %v\n", r.seed, r.mapName, s.describe(r.over))
+		}
+		fmt.Println()
+	}
+	s.printSweep(results)
+}
+
+func newSetup(catalogPath, mapDir, mapName, symmetry, teams, victory string, minutes float64, players string) (*setup, error) {
+	catalog, err := sim.LoadCatalog(catalogPath)
+	if err != nil {
+		return nil, err
+	}
+	if minutes <= 0 {
+		return nil, fmt.Errorf("-minutes must be more than 0")
+	}
+	conditions, err := sim.ParseVictoryConditions(victory, minutes*60)
+	if err != nil {
+		return nil, err
+	}
+	s := &setup{
+		catalog: catalog,
+		mapDir:  mapDir,
+		choice:  sim.MapChoice{Name: mapName, Options: sim.DefaultMapOptions()},
+		victory: conditions,
+	}
+	for _, name := range strings.Split(players, ",") {
+		player, err := newPlayer(name)
+		if err != nil {
+			return nil, err
+		}
+		s.names = append(s.names, name)
+		s.players = append(s.players, player)
+	}
+	s.choice.Options.Players = len(s.players)
+	if symmetry != "" {
+		s.choice.Options.Symmetry = symmetry
+	}
+	if teams != "" {
+		s.choice.Teams, err = sim.ParseTeams(teams)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// playAll plays runs matches from consecutive seeds, parallel at a time,
+// counting them off on stderr. The results are in seed order whatever order
+// they finish in.
+func (s *setup) playAll(seed int64, runs int, parallel int) ([]run, error) {
+	results := make([]run, runs)
+	errs := make([]error, runs)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for range min(parallel, runs) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], errs[i] = s.play(seed + int64(i))
+				if runs > 1 {
+					mu.Lock()
+					done++
+					fmt.Fprintf(os.Stderr, "\r%v/%v matches", done, runs)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range runs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if runs > 1 {
+		fmt.Fprintln(os.Stderr)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// play plays one match out to the end, at most the time limit.
+func (s *setup) play(seed int64) (run, error) {
+	choice := s.choice
+	choice.Options.Seed = seed
+	m, err := sim.StartingMap(choice, s.mapDir, s.catalog)
+	if err != nil {
+		return run{}, err
+	}
+	if len(m.Bases) != len(s.players) {
+		return run{}, fmt.Errorf("%v is for %v players, not %v", m.Name, len(m.Bases), len(s.players))
+	}
+
+	game := sim.NewGame(seed, s.catalog, m, s.victory)
+	simulation := sim.NewSimulation(game)
+	seats := make([]player, len(s.players))
+	for i, newPlayer := range s.players {
+		seats[i] = newPlayer(sim.PlayerID(i+1), s.catalog)
+	}
+	r := run{seed: seed, mapName: m.Name, rejected: make(map[sim.PlayerID]int)}
+	for simulation.Result() == nil {
+		tick := simulation.Tick()
+		for i, seat := range seats {
+			pid := sim.PlayerID(i + 1)
+			for _, c := range seat.act(game, tick) {
+				args, err := json.Marshal(c.args)
+				if err == nil {
+					err = simulation.Queue(pid, c.key, args)
+				}
+				if err != nil {
+					r.rejected[pid]++
+				}
+			}
+		}
+		for _, rejection := range simulation.Step() {
+			r.rejected[rejection.Player]++
+		}
+	}
+	r.over = simulation.Result()
+	return r, nil
+}
+
+func (s *setup) printRun(r run) {
+	fmt.Printf("seed %v on %v: %v\n\n", r.seed, r.mapName, s.describe(r.over))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "player\t\tgathered\ttrained\tlost\tkilled\tbuildings lost\tfirst barracks\tscore\trejected")
+	for i, name := range s.names {
+		pid := sim.PlayerID(i + 1)
+		stats := r.over.Stats[pid]
+		barracks := "-"
+		if t, ok := stats.FirstBuilt["barracks"]; ok {
+			barracks = clock(t)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%.0f\t%v\t%v\t%v\t%v\t%v\t%.0f\t%v\n", pid, name,
+			stats.ResourcesGathered, stats.UnitsTrained, stats.UnitsLost, stats.UnitsKilled,
+			stats.BuildingsLost, barracks, stats.Score, r.rejected[pid])
+	}
+	w.Flush()
+}
+
+// printSweep sums up many runs: how often each seat won, and what an
+// average match looked like for it.
+func (s *setup) printSweep(results []run) {
+	var draws int
+	var length float64
+	for _, r := range results {
+		if len(r.over.Winners) == 0 {
+			draws++
+		}
+		length += r.over.ElapsedTime
+	}
+	n := float64(len(results))
+	fmt.Printf("%v matches, %v drawn, %v long on average\n\n", len(results), draws, clock(length/n))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "player\t\twins\twin rate\tgathered\tlost\tkilled\tfirst barracks\tbuilt barracks")
+	for i, name := range s.names {
+		pid := sim.PlayerID(i + 1)
+		var wins, built int
+		var gathered, lost, killed, barracks float64
+		for _, r := range results {
+			stats := r.over.Stats[pid]
+			for _, winner := range r.over.Winners {
+				if winner == pid {
+					wins++
+				}
+			}
+			gathered += stats.ResourcesGathered
+			lost += float64(stats.UnitsLost)
+			killed += float64(stats.UnitsKilled)
+			if t, ok := stats.FirstBuilt["barracks"]; ok {
+				barracks += t
+				built++
+			}
+		}
+		firstBarracks := "-"
+		if built > 0 {
+			firstBarracks = clock(barracks / float64(built))
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.1f%%\t%.0f\t%.1f\t%.1f\t%v\t%.0f%%\n", pid, name,
+			wins, 100*float64(wins)/n, gathered/n, lost/n, killed/n, firstBarracks, 100*float64(built)/n)
+	}
+	w.Flush()
+}
+
+// describe says who won a match, how and when.
+func (s *setup) describe(over *sim.GameOver) string {
+	if len(over.Winners) == 0 {
+		return fmt.Sprintf("draw by %v at %v", over.Reason, clock(over.ElapsedTime))
+	}
+	var winners []string
+	for _, pid := range over.Winners {
+		winners = append(winners, fmt.Sprintf("player %v (%v)", pid, s.names[pid-1]))
+	}
+	return fmt.Sprintf("%v won by %v at %v", strings.Join(winners, " and "), over.Reason, clock(over.ElapsedTime))
+}
+
+// clock formats seconds of game time as minutes and seconds.
+func clock(seconds float64) string {
+	total := int(seconds + 0.5)
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"hackcu2025/sim"
+)
+
+// A player decides what one seat does. act is called before every tick
+// with the game as it stands and returns the commands to queue for that
+// tick. Every run gets its own players.
+type player interface {
+	act(g *sim.Game, tick int) []command
+}
+
+// A newPlayerFunc makes a player for a seat in a run.
+type newPlayerFunc func(pid sim.PlayerID, catalog *sim.Catalog) player
+
+type command struct {
+	key  string
+	args any
+}
+
+// A strategy is one of the built-in AIs. They all play the same way, and
+// differ only in how hard they work their economy before going to war.
+type strategy struct {
+	// How many builders to keep, counting the ones the map starts with.
+	// Barracks wait until there are this many.
+	builders int
+	// How many barracks to put up, one at a time.
+	barracks int
+	// How many knights to gather at home before sending them all out.
+	wave int
+}
+
+var strategies = map[string]strategy{
+	// A barracks straight away and small waves of knights.
+	"rush": {builders: 3, barracks: 1, wave: 3},
+	// A bigger economy first, then two barracks and big waves.
+	"boom": {builders: 8, barracks: 2, wave: 10},
+}
+
+// newPlayer makes a player from its -players name: a strategy, "idle" for
+// one that never does anything, or a script file.
+func newPlayer(name string) (newPlayerFunc, error) {
+	if name == "idle" {
+		return func(sim.PlayerID, *sim.Catalog) player { return idle{} }, nil
+	}
+	if s, ok := strategies[name]; ok {
+		return func(pid sim.PlayerID, catalog *sim.Catalog) player {
+			return &ai{strategy: s, pid: pid, catalog: catalog, sent: make(map[sim.EntityID]bool)}
+		}, nil
+	}
+	if path, ok := strings.CutPrefix(name, "script:"); ok {
+		script, err := loadScript(path)
+		if err != nil {
+			return nil, err
+		}
+		return func(sim.PlayerID, *sim.Catalog) player { return &scripted{script: script} }, nil
+	}
+	return nil, fmt.Errorf("unknown player %q (want idle, %v or script:<file>)", name, strings.Join(strategyNames(), ", "))
+}
+
+func strategyNames() []string {
+	var names []string
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type idle struct{}
+
+func (idle) act(*sim.Game, int) []command {
+	return nil
+}
+
+// A script is a list of commands for one player, in the same form as a
+// replay's: {"tick": 40, "key": "placeBuilding", "args": {...}}. Any player
+// field is ignored. Entity ids depend on the map and seed, so a script is
+// only good for the setup it was written against.
+type script []sim.PlayerCommand
+
+func loadScript(path string) (script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var commands script
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	slices.SortStableFunc(commands, func(a, b sim.PlayerCommand) int {
+		return a.Tick - b.Tick
+	})
+	return commands, nil
+}
+
+type scripted struct {
+	script script
+	next   int
+}
+
+func (s *scripted) act(g *sim.Game, tick int) []command {
+	var commands []command
+	for s.next < len(s.script) && s.script[s.next].Tick <= tick {
+		c := s.script[s.next]
+		commands = append(commands, command{c.Key, c.Args})
+		s.next++
+	}
+	return commands
+}
+
+// An ai plays a strategy. It looks the game over once a second of game
+// time, seeing all of it, fog and all: builders that have nothing to do go
+// gathering, it trains builders and then knights, puts up its barracks and
+// sends each wave of knights at the nearest enemy town hall.
+type ai struct {
+	strategy
+	pid     sim.PlayerID
+	catalog *sim.Catalog
+	// Where the next building goes, as an index into buildingSites.
+	site int
+	// The town hall the knights were last sent at, and the knights that
+	// were sent.
+	target sim.EntityID
+	sent   map[sim.EntityID]bool
+}
+
+// The AI only thinks this often, in ticks.
+const thinkEvery = sim.TickRate
+
+// Spots for new buildings, as offsets from the town hall, nearest first.
+// Placing one that's blocked just fails, and the next attempt moves on.
+var buildingSites = func() []sim.GridLocation {
+	var sites []sim.GridLocation
+	for x := -12; x <= 12; x += 2 {
+		for z := -12; z <= 12; z += 2 {
+			if d := math.Hypot(float64(x), float64(z)); d >= 6 && d <= 12 {
+				sites = append(sites, sim.GridLocation{X: x, Z: z})
+			}
+		}
+	}
+	slices.SortStableFunc(sites, func(a, b sim.GridLocation) int {
+		return (a.X*a.X + a.Z*a.Z) - (b.X*b.X + b.Z*b.Z)
+	})
+	return sites
+}()
+
+func (a *ai) act(g *sim.Game, tick int) []command {
+	if tick%thinkEvery != 0 {
+		return nil
+	}
+	state := g.GetState()
+	me, ok := state.Players[a.pid]
+	if !ok {
+		return nil
+	}
+	home, ok := me.Buildings[me.PrimaryTownHall]
+	if !ok {
+		return nil
+	}
+	var commands []command
+
+	// Builders: keep them all busy, finishing buildings before gathering.
+	var unfinished []sim.EntityID
+	var barracks, ready int
+	var queued = map[string]int{}
+	for _, id := range slices.Sorted(maps.Keys(me.Buildings)) {
+		b := me.Buildings[id]
+		if !b.IsComplete() {
+			unfinished = append(unfinished, id)
+		}
+		if b.BuildingType == "barracks" {
+			barracks++
+			if b.IsComplete() {
+				ready++
+			}
+		}
+		for _, item := range b.Queue {
+			queued[item.UnitType]++
+		}
+	}
+	working := map[sim.EntityID]bool{}
+	for _, b := range me.Builders {
+		if b.Order.Kind == "build" {
+			working[b.Order.TargetID] = true
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(me.Builders)) {
+		b := me.Builders[id]
+		if len(unfinished) > 0 && b.Order.Kind != "build" && !working[unfinished[0]] {
+			commands = append(commands, command{"build", sim.BuildCommand{ID: id, BuildingID: unfinished[0]}})
+			working[unfinished[0]] = true
+			unfinished = unfinished[1:]
+			continue
+		}
+		if b.Order.Kind == "idle" {
+			commands = append(commands, command{"gather", sim.GatherCommand{ID: id, ResourceType: scarcest(me)}})
+		}
+	}
+
+	// What's left over goes to the economy, then barracks, then knights.
+	bank := sim.Cost{Gold: me.Gold, Stone: me.Stone, Wood: me.Wood}
+	spend := func(cost sim.Cost) bool {
+		if bank.Gold < cost.Gold || bank.Stone < cost.Stone || bank.Wood < cost.Wood {
+			return false
+		}
+		bank.Gold -= cost.Gold
+		bank.Stone -= cost.Stone
+		bank.Wood -= cost.Wood
+		return true
+	}
+	if len(me.Builders)+queued["builder"] < a.builders && queued["builder"] == 0 && spend(a.catalog.Units["builder"].Cost) {
+		commands = append(commands, command{"trainUnit", sim.TrainUnitCommand{UnitType: "builder"}})
+	}
+	if len(me.Builders) >= a.builders && barracks < a.barracks && len(unfinished) == 0 && spend(a.catalog.Buildings["barracks"].Cost) {
+		offset := buildingSites[a.site%len(buildingSites)]
+		a.site++
+		pos := sim.Float3{X: float64(home.Position.X + offset.X), Z: float64(home.Position.Z + offset.Z)}
+		commands = append(commands, command{"placeBuilding", sim.PlaceBuildingCommand{TYPE: "barracks", POS: pos}})
+	}
+	if queued["knight"] < ready && spend(a.catalog.Units["knight"].Cost) {
+		commands = append(commands, command{"trainUnit", sim.TrainUnitCommand{UnitType: "knight"}})
+	}
+
+	// Knights: once a wave is ready, send it at the nearest enemy town
+	// hall, and send new knights after it while any of it is still alive.
+	// If the town hall falls, everyone moves on to the next one.
+	target, pos, ok := nearestEnemyTownHall(state, a.pid, home.GetPosition())
+	if !ok {
+		return commands
+	}
+	for id := range a.sent {
+		if _, ok := me.Fighters[id]; !ok || target != a.target {
+			delete(a.sent, id)
+		}
+	}
+	var wave []sim.EntityID
+	for _, id := range slices.Sorted(maps.Keys(me.Fighters)) {
+		if !a.sent[id] {
+			wave = append(wave, id)
+		}
+	}
+	if len(wave) >= a.wave || (len(wave) > 0 && len(a.sent) > 0) {
+		a.target = target
+		for _, id := range wave {
+			a.sent[id] = true
+			commands = append(commands, command{"moveUnit", sim.MoveTroopCommand{ID: id, POS: pos, TYPE: "aggro"}})
+		}
+	}
+	return commands
+}
+
+// scarcest is the resource a player has least of, weighting gold, which
+// knights and builders cost, the heaviest.
+func scarcest(me sim.PlayerState) string {
+	weighted := []struct {
+		name   string
+		amount float64
+	}{{"gold", me.Gold / 3}, {"stone", me.Stone}, {"wood", me.Wood}}
+	best := weighted[0]
+	for _, w := range weighted[1:] {
+		if w.amount < best.amount {
+			best = w
+		}
+	}
+	return best.name
+}
+
+func nearestEnemyTownHall(state sim.GameState, pid sim.PlayerID, from sim.Float3) (sim.EntityID, sim.Float3, bool) {
+	me := state.Players[pid]
+	var target sim.EntityID
+	var pos sim.Float3
+	found := false
+	best := math.Inf(1)
+	for _, other := range slices.Sorted(maps.Keys(state.Players)) {
+		them := state.Players[other]
+		if other == pid || (me.Team != 0 && them.Team == me.Team) {
+			continue
+		}
+		for _, id := range slices.Sorted(maps.Keys(them.Buildings)) {
+			b := them.Buildings[id]
+			if b.BuildingType != "townhall" {
+				continue
+			}
+			p := b.GetPosition()
+			if d := math.Hypot(p.X-from.X, p.Z-from.Z); d < best {
+				target, pos, best, found = id, p, d, true
+			}
+		}
+	}
+	return target, pos, found
+}
@@ -6,3 +6,9 @@ require (
 	github.com/gorilla/mux v1.8.1 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
 )
+
+require hackcu2025/sim v0.0.0
+
+// The simulation is its own module so other programs can use it without
+// the server's dependencies.
+replace hackcu2025/sim => ./sim
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"hackcu2025/sim"
+)
+
+// mapDir is where matches' map files come from, set with -maps. Empty
+// means the built-in maps.
+var mapDir string
+
+// validateMaps checks map files for -validate and prints what's wrong with
+// each. With no paths it checks every map LoadMap can find. It returns the
+// exit status.
+func validateMaps(paths []string) int {
+	status := 0
+	check := func(what string, err error) {
+		if err != nil {
+			fmt.Printf("%v:\n  %v\n", what, strings.ReplaceAll(err.Error(), "\n", "\n  "))
+			status = 1
+			return
+		}
+		fmt.Printf("%v: ok\n", what)
+	}
+	if len(paths) == 0 {
+		names, err := sim.MapNames(mapDir)
+		if err != nil {
+			check("maps", err)
+		}
+		for _, name := range names {
+			_, err := sim.LoadMap(mapDir, name, gameCatalog)
+			check(name, err)
+		}
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			_, err = sim.ParseMap(data, gameCatalog)
+		}
+		check(path, err)
+	}
+	return status
+}
+
+// parseMapChoice reads the map parameters of a /start request: map to play
+// a map file, or else mapSeed, symmetry and players for a generated map,
+// and teams, one per player in base order, e.g. ?teams=1,2,1,2. Without a
+// mapSeed every match gets a fresh map.
+func parseMapChoice(query url.Values) (sim.MapChoice, error) {
+	choice := sim.MapChoice{Name: query.Get("map"), Options: sim.DefaultMapOptions()}
+	choice.Options.Seed = time.Now().UnixNano()
+	if query.Has("mapSeed") {
+		seed, err := strconv.ParseInt(query.Get("mapSeed"), 10, 64)
+		if err != nil {
+			return choice, fmt.Errorf("bad map seed %q", query.Get("mapSeed"))
+		}
+		choice.Options.Seed = seed
+	}
+	if query.Has("symmetry") {
+		choice.Options.Symmetry = query.Get("symmetry")
+	}
+	if query.Has("players") {
+		players, err := strconv.Atoi(query.Get("players"))
+		if err != nil {
+			return choice, fmt.Errorf("bad player count %q", query.Get("players"))
+		}
+		choice.Options.Players = players
+	}
+	if query.Has("teams") {
+		teams, err := sim.ParseTeams(query.Get("teams"))
+		if err != nil {
+			return choice, err
+		}
+		choice.Teams = teams
+	}
+	return choice, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"hackcu2025/sim"
+)
+
+// Matches run their simulation in real time, one tick every tickInterval.
+const tickInterval = time.Second / sim.TickRate
+
+// maxCatchUpTicks bounds how many ticks one wake-up of the match loop may
+// run. If the loop falls further behind than this, the backlog is dropped
+// rather than fast-forwarding the game.
+const maxCatchUpTicks = 5
+
+// A Match is one running game: its own simulation, its own set of
+// connected players and its own listener.
+type Match struct {
+	port        string
+	sim         *sim.Simulation
+	gameMutex   sync.Mutex
+	connections map[*websocket.Conn]*client
+	connMutex   sync.Mutex
+	histories   map[sim.PlayerID]*stateHistory
+	server      *http.Server
+
+	// A delayed copy of the match for spectators to watch, or nil if they
+	// watch live.
+	spectatorFeed *sim.Delayed
+
+	// Who holds each player's seat, guarded by connMutex.
+	seats map[sim.PlayerID]*seat
+}
+
+// A client is one connection's view of the match. baseSeq is the newest
+// state we know the client holds; deltas are computed against it.
+type client struct {
+	playerID sim.PlayerID
+	baseSeq  int
+}
+
+// The matches being hosted, by port, for /matches to list.
+var matches = make(map[string]*Match)
+var matchesMutex sync.Mutex
+var numGames = 0
+
+func newMatch(portNumber string, gameMap *sim.Map, victory sim.VictoryConditions, spectatorDelay float64) (*Match, error) {
+	m := &Match{
+		port:        portNumber,
+		sim:         sim.NewSimulation(initGame(time.Now().UnixNano(), gameMap, victory)),
+		connections: make(map[*websocket.Conn]*client),
+		histories:   make(map[sim.PlayerID]*stateHistory),
+		seats:       make(map[sim.PlayerID]*seat),
+	}
+	if spectatorDelay > 0 {
+		feed, err := sim.NewDelayed(m.sim, int(spectatorDelay*sim.TickRate))
+		if err != nil {
+			return nil, err
+		}
+		m.spectatorFeed = feed
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+portNumber, m.handleConnections)
+	m.server = &http.Server{Addr: portNumber, Handler: mux}
+	return m, nil
+}
+
+// nextMatchPort reserves the port for a new match.
+func nextMatchPort() string {
+	matchesMutex.Lock()
+	defer matchesMutex.Unlock()
+	numGames++
+	return portForGame(numGames)
+}
+
+func registerMatch(m *Match) {
+	matchesMutex.Lock()
+	matches[m.port] = m
+	matchesMutex.Unlock()
+}
+
+func unregisterMatch(m *Match) {
+	matchesMutex.Lock()
+	delete(matches, m.port)
+	matchesMutex.Unlock()
+}
+
+// A matchListing is one running match as /matches lists it, for finding a
+// game to join or watch.
+type matchListing struct {
+	Port        string  `json:"port"`
+	Map         string  `json:"map"`
+	Seats       int     `json:"seats"`
+	Playing     int     `json:"playing"`
+	ElapsedTime float64 `json:"elapsedTime"`
+	// How far behind live play spectators watch, in seconds.
+	SpectatorDelay float64 `json:"spectatorDelay"`
+}
+
+func (m *Match) listing() matchListing {
+	m.gameMutex.Lock()
+	listing := matchListing{
+		Port:        m.port,
+		Map:         m.sim.Game().Map().Name,
+		Seats:       len(m.sim.Game().Map().Bases),
+		ElapsedTime: float64(m.sim.Tick()) / sim.TickRate,
+	}
+	if m.spectatorFeed != nil {
+		listing.SpectatorDelay = float64(m.spectatorFeed.Delay()) / sim.TickRate
+	}
+	m.gameMutex.Unlock()
+
+	m.connMutex.Lock()
+	for _, s := range m.seats {
+		if s.conn != nil {
+			listing.Playing++
+		}
+	}
+	m.connMutex.Unlock()
+	return listing
+}
+
+// getMatches lists the matches being hosted, in port order.
+func getMatches(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	matchesMutex.Lock()
+	hosted := slices.Collect(maps.Values(matches))
+	matchesMutex.Unlock()
+	slices.SortFunc(hosted, func(a, b *Match) int {
+		return strings.Compare(a.port, b.port)
+	})
+
+	listings := []matchListing{}
+	for _, m := range hosted {
+		listings = append(listings, m.listing())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listings)
+}
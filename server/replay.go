@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"hackcu2025/sim"
+)
+
+// replayDir is where finished matches are saved. Empty turns recording off.
+var replayDir = "replays"
+
+// saveReplay writes the match to replayDir, if recording is on.
+func (m *Match) saveReplay() {
+	if replayDir == "" {
+		return
+	}
+	replay, err := m.sim.Replay()
+	if err == nil {
+		err = os.MkdirAll(replayDir, 0o755)
+	}
+	if err != nil {
+		log.Printf("Error saving replay of game %v: %v", m.port, err)
+		return
+	}
+	name := fmt.Sprintf("%v-%v.replay.gz", time.Now().Format("20060102-150405"), strings.TrimPrefix(m.port, ":"))
+	path := filepath.Join(replayDir, name)
+	if err := sim.SaveReplay(path, replay); err != nil {
+		log.Printf("Error saving replay of game %v: %v", m.port, err)
+		return
+	}
+	log.Printf("Saved replay of game %v to %v", m.port, path)
+}
+
+// Replay viewer controls.
+const (
+	replayPlay  = "play"
+	replayPause = "pause"
+	replaySeek  = "seek"
+	replaySpeed = "speed"
+)
+
+const maxReplaySpeed = 16
+
+type replayControl struct {
+	Tick  int     `json:"tick"`
+	Speed float64 `json:"speed"`
+}
+
+// A ReplayStatus tells viewers where playback is.
+type ReplayStatus struct {
+	Tick   int     `json:"tick"`
+	Ticks  int     `json:"ticks"`
+	Paused bool    `json:"paused"`
+	Speed  float64 `json:"speed"`
+}
+
+// A replayer plays a recorded match back to spectators over the same
+// snapshot and delta messages a live match uses, showing everything. Viewers
+// can pause, seek and change the speed; seeking backwards simulates the
+// match again from the start.
+type replayer struct {
+	match  *Match
+	replay *sim.Replay
+	// Index of the next recorded command to feed in.
+	next   int
+	paused bool
+	speed  float64
+	// Ticks due at the current speed but not yet simulated.
+	owed float64
+	// Set when viewers need a fresh state even though no tick has passed,
+	// and when the states they hold are no longer any use as a base.
+	dirty bool
+	reset bool
+}
+
+func newReplayer(portNumber string, replay *sim.Replay) (*replayer, error) {
+	simulation, err := replay.NewSimulation()
+	if err != nil {
+		return nil, err
+	}
+	r := &replayer{
+		match: &Match{
+			port:        portNumber,
+			sim:         simulation,
+			connections: make(map[*websocket.Conn]*client),
+			histories:   make(map[sim.PlayerID]*stateHistory),
+		},
+		replay: replay,
+		speed:  1,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/replay", r.handleViewer)
+	r.match.server = &http.Server{Addr: portNumber, Handler: mux}
+	return r, nil
+}
+
+// runReplay serves a replay file until the process is stopped.
+func runReplay(path string) error {
+	replay, err := sim.LoadReplay(path)
+	if err != nil {
+		return err
+	}
+	r, err := newReplayer(":8080", replay)
+	if err != nil {
+		return err
+	}
+	log.Printf("Replaying %v (%v ticks) on %v/replay", path, replay.Ticks, r.match.port)
+	go r.play()
+	return r.match.server.ListenAndServe()
+}
+
+// step simulates the next tick. If the match ends earlier than the replay
+// says it did, which happens when recorded commands no longer decode or
+// the file is damaged, the replay is cut short there, since nothing moves
+// after the end.
+func (r *replayer) step() {
+	var err error
+	r.next, err = r.match.sim.ReplayStep(r.replay.Commands, r.next)
+	if err != nil {
+		log.Printf("Replay: %v", err)
+	}
+	if r.match.sim.Result() != nil && r.match.sim.Tick() < r.replay.Ticks {
+		log.Printf("Replay: the match ended at tick %v, not %v", r.match.sim.Tick(), r.replay.Ticks)
+		r.replay.Ticks = r.match.sim.Tick()
+	}
+}
+
+// seek simulates up to tick, starting over if it's in the past. Viewers get
+// a full snapshot afterwards, since the states they hold may be from a
+// different point in the match.
+func (r *replayer) seek(tick int) error {
+	tick = min(max(tick, 0), r.replay.Ticks)
+	if tick < r.match.sim.Tick() {
+		simulation, err := r.replay.NewSimulation()
+		if err != nil {
+			return err
+		}
+		r.match.sim = simulation
+		r.next = 0
+	}
+	for r.match.sim.Tick() < tick && r.match.sim.Result() == nil {
+		r.step()
+	}
+	r.owed = 0
+	r.dirty = true
+	r.reset = true
+	return nil
+}
+
+func (r *replayer) play() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	m := r.match
+	for range ticker.C {
+		m.gameMutex.Lock()
+		if !r.paused {
+			r.owed += r.speed
+		}
+		for r.owed >= 1 && m.sim.Tick() < r.replay.Ticks && m.sim.Result() == nil {
+			r.step()
+			r.owed--
+			r.dirty = true
+		}
+		ended := !r.paused && m.sim.Tick() >= r.replay.Ticks
+		if ended {
+			r.paused = true
+			r.owed = 0
+		}
+		var states map[sim.PlayerID]sim.GameState
+		seq := m.sim.Tick()
+		if r.dirty {
+			states = m.sim.Game().GetStates()
+			r.dirty = false
+		}
+		reset := r.reset
+		r.reset = false
+		status := r.status()
+		m.gameMutex.Unlock()
+
+		if reset {
+			m.connMutex.Lock()
+			clear(m.histories)
+			for _, c := range m.connections {
+				c.baseSeq = -1
+			}
+			m.connMutex.Unlock()
+		}
+		if states != nil {
+			m.broadcast(seq, states)
+		}
+		if ended {
+			r.sendStatus(status)
+		}
+	}
+}
+
+func (r *replayer) status() ReplayStatus {
+	return ReplayStatus{
+		Tick:   r.match.sim.Tick(),
+		Ticks:  r.replay.Ticks,
+		Paused: r.paused,
+		Speed:  r.speed,
+	}
+}
+
+func (r *replayer) sendStatus(status ReplayStatus) {
+	encoded, err := json.Marshal(map[string]any{"replayStatus": status})
+	if err != nil {
+		log.Printf("Error marshalling JSON: %v", err)
+		return
+	}
+	m := r.match
+	m.connMutex.Lock()
+	defer m.connMutex.Unlock()
+	for conn := range m.connections {
+		if err := conn.WriteMessage(websocket.TextMessage, encoded); err != nil {
+			log.Printf("Error writing message: %v", err)
+		}
+	}
+}
+
+// control carries out one viewer control message.
+func (r *replayer) control(key string, raw json.RawMessage) error {
+	var args replayControl
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return sim.RejectCommand(key, "malformed command: %v", err)
+	}
+	m := r.match
+	m.gameMutex.Lock()
+	defer m.gameMutex.Unlock()
+	switch key {
+	case replayPlay:
+		r.paused = false
+	case replayPause:
+		r.paused = true
+		r.owed = 0
+	case replaySeek:
+		if err := r.seek(args.Tick); err != nil {
+			return sim.RejectCommand(key, "%v", err)
+		}
+	case replaySpeed:
+		if args.Speed <= 0 || args.Speed > maxReplaySpeed {
+			return sim.RejectCommand(key, "speed must be above 0 and at most %v", maxReplaySpeed)
+		}
+		r.speed = args.Speed
+	}
+	return nil
+}
+
+// handleViewer connects a spectator to the replay. Viewers send the same
+// ack and resync messages as players, plus play, pause, seek and speed.
+func (r *replayer) handleViewer(w http.ResponseWriter, req *http.Request) {
+	ws, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to websocket: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	m := r.match
+	m.gameMutex.Lock()
+	hello := map[string]any{"replay": r.status(), "map": r.replay.Map, "result": r.replay.Result}
+	r.dirty = true
+	m.gameMutex.Unlock()
+
+	m.connMutex.Lock()
+	m.connections[ws] = &client{playerID: 0, baseSeq: -1}
+	err = ws.WriteJSON(hello)
+	m.connMutex.Unlock()
+	if err != nil {
+		log.Printf("Error sending replay info: %v", err)
+	}
+
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			m.connMutex.Lock()
+			delete(m.connections, ws)
+			m.connMutex.Unlock()
+			return
+		}
+		var msgTemp []map[string]json.RawMessage
+		if err := json.Unmarshal(message, &msgTemp); err != nil {
+			m.sendError(ws, sim.RejectCommand("", "malformed message: %v", err))
+			continue
+		}
+		for i := range msgTemp {
+			for _, key := range slices.Sorted(maps.Keys(msgTemp[i])) {
+				raw := msgTemp[i][key]
+				switch key {
+				case "ack", "resync":
+					err = m.acknowledge(ws, key, raw)
+				case replayPlay, replayPause, replaySeek, replaySpeed:
+					err = r.control(key, raw)
+				case "noop":
+					err = nil
+				default:
+					err = sim.RejectCommand(key, "not available in a replay")
+				}
+				if err != nil {
+					m.sendError(ws, err)
+				}
+			}
+		}
+		m.gameMutex.Lock()
+		status := r.status()
+		m.gameMutex.Unlock()
+		r.sendStatus(status)
+	}
+}
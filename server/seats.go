@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"hackcu2025/sim"
+)
+
+// seatGracePeriod is how long a dropped player's seat is held for them.
+// Their units carry on with their last orders in the meantime. After that
+// they resign, and their units and buildings stay where they were left.
+const seatGracePeriod = 60 * time.Second
+
+// A seat is a player's place in a match. The player is handed its token
+// when they first connect, and presents it as ?token= to take the seat back
+// after their connection drops.
+type seat struct {
+	token string
+	// The connection playing the seat, or nil if it's dropped.
+	conn      *websocket.Conn
+	droppedAt time.Time
+	// Set once the player has been gone too long and has been resigned.
+	abandoned bool
+}
+
+var errUnknownSession = errors.New("no seat in this game for that session token")
+var errSeatGivenUp = errors.New("that seat was given up after its player was gone too long")
+
+func newSessionToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// claimSeat seats a connection, with connMutex held. With a token it
+// reclaims that token's seat, taking it over from any connection still
+// playing it. Without one it takes the lowest seat that's never been
+// played. A seat that's been played is only ever its token's, so nobody
+// else gets a dropped player's army.
+func (m *Match) claimSeat(ws *websocket.Conn, token string) (sim.PlayerID, *seat, error) {
+	if token != "" {
+		for _, pid := range slices.Sorted(maps.Keys(m.seats)) {
+			s := m.seats[pid]
+			if s.token != token {
+				continue
+			}
+			if s.abandoned {
+				return 0, nil, errSeatGivenUp
+			}
+			if s.conn != nil {
+				delete(m.connections, s.conn)
+				s.conn.Close()
+			}
+			s.conn = ws
+			return pid, s, nil
+		}
+		return 0, nil, errUnknownSession
+	}
+	for i := range m.sim.Game().Map().Bases {
+		pid := sim.PlayerID(i + 1)
+		if _, ok := m.seats[pid]; ok {
+			continue
+		}
+		s := &seat{token: newSessionToken(), conn: ws}
+		m.seats[pid] = s
+		return pid, s, nil
+	}
+	return 0, nil, errors.New("the game is full")
+}
+
+// leaveSeat starts the grace period for a dropped connection's seat, with
+// connMutex held. It does nothing if another connection has already taken
+// the seat over.
+func (m *Match) leaveSeat(ws *websocket.Conn, pid sim.PlayerID) {
+	if s, ok := m.seats[pid]; ok && s.conn == ws {
+		s.conn = nil
+		s.droppedAt = time.Now()
+		log.Printf("Player %v dropped from game %v, holding their seat for %v", pid, m.port, seatGracePeriod)
+	}
+}
+
+// resignAbandonedSeats resigns the players whose seats have been empty for
+// longer than the grace period. Each is only resigned once.
+func (m *Match) resignAbandonedSeats() {
+	var abandoned []sim.PlayerID
+	m.connMutex.Lock()
+	for _, pid := range slices.Sorted(maps.Keys(m.seats)) {
+		s := m.seats[pid]
+		if s.conn == nil && !s.abandoned && time.Since(s.droppedAt) > seatGracePeriod {
+			log.Printf("Player %v gave up their seat in game %v", pid, m.port)
+			s.abandoned = true
+			abandoned = append(abandoned, pid)
+		}
+	}
+	m.connMutex.Unlock()
+
+	m.gameMutex.Lock()
+	for _, pid := range abandoned {
+		if err := m.sim.Queue(pid, "resign", json.RawMessage("{}")); err != nil {
+			log.Printf("Can't resign player %v from game %v: %v", pid, m.port, err)
+		}
+	}
+	m.gameMutex.Unlock()
+}
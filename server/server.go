@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
+	"maps"
 	"net/http"
-	"sync"
+	"os"
+	"slices"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"hackcu2025/sim"
 )
 
 var upgrader = websocket.Upgrader{
@@ -18,212 +22,359 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-var game Game
-var numGames = 0
-var connections = make(map[*websocket.Conn]PlayerID)
-var connMutex sync.Mutex
-
-type MoveTroopCommand struct {
-	ID  int    `json:"id"`
-	POS Float3 `json:"pos"`
-}
-
-type PlaceBuildingCommand struct {
-	TYPE string `json:"type"`
-	POS  Float3 `json:"pos"`
-}
-
-type AttackCommand struct {
-	TARGET_ID   int `json:"target_id"`
-	ATTACKER_ID int `json:"attacker_id"`
-}
-
-func handleConnections(w http.ResponseWriter, r *http.Request) {
+func (m *Match) handleConnections(w http.ResponseWriter, r *http.Request) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatalf("Failed to upgrade to websocket: %v", err)
+		log.Printf("Failed to upgrade to websocket: %v", err)
+		return
 	}
 	defer ws.Close()
 
-	connMutex.Lock()
-	playerID := PlayerID(len(connections) + 1)
-	if playerID > 2 {
-		log.Printf("Too many players connected")
-		connMutex.Unlock()
-		return
+	m.connMutex.Lock()
+	playerID := spectator
+	var token string
+	if r.URL.Query().Get("spectate") == "true" {
+		log.Printf("Spectator connected to game %v", m.port)
+	} else {
+		id, seat, err := m.claimSeat(ws, r.URL.Query().Get("token"))
+		if err != nil {
+			log.Printf("Refused connection to game %v: %v", m.port, err)
+			ws.WriteJSON(map[string]any{"error": &sim.CommandError{Reason: err.Error()}})
+			m.connMutex.Unlock()
+			return
+		}
+		playerID, token = id, seat.token
+		log.Printf("Player %v connected to game %v", playerID, m.port)
 	}
-	log.Printf("Player %v connected", playerID)
-	connections[ws] = playerID
+	// A new connection, even a resumed one, starts from a full snapshot.
+	m.connections[ws] = &client{playerID: playerID, baseSeq: -1}
 
-	// Send player ID to the client
-	idMessage := map[string]any{"playerId": playerID}
+	// Send player ID and session token to the client
+	idMessage := map[string]any{"playerId": playerID, "spectator": playerID == spectator, "map": m.sim.Game().Map()}
+	if token != "" {
+		idMessage["token"] = token
+	}
 	idEncoded, err := json.Marshal(idMessage)
 	if err != nil {
 		log.Printf("Error marshalling JSON: %v", err)
+		m.connMutex.Unlock()
 		return
 	}
 
 	err = ws.WriteMessage(websocket.TextMessage, idEncoded)
 
-	connMutex.Unlock()
+	m.connMutex.Unlock()
 	if err != nil {
 		log.Printf("Error sending player ID: %v", err)
-		connMutex.Lock()
-		delete(connections, ws)
-		connMutex.Unlock()
+		m.disconnect(ws, playerID)
 		return
 	}
 
 	for {
-		var msgTemp []map[string]any
-		err = ws.ReadJSON(&msgTemp)
+		_, message, err := ws.ReadMessage()
 		if err != nil {
 			log.Printf("Error reading message: %v", err)
-			connMutex.Lock()
-			delete(connections, ws)
-			connMutex.Unlock()
+			m.disconnect(ws, playerID)
 			break
-		} else {
-			if msgTemp[0]["noop"] != true {
-				for i := range msgTemp {
-					for key := range msgTemp[i] {
-						if command, ok := msgTemp[i][key].(map[string]any); ok {
-							log.Printf("Command: %v", command)
-							log.Printf("key: %v", key)
-
-							switch key {
-							case "moveUnit":
-								pos := mapToFloat3(command["pos"].(map[string]any))
-								id := EntityID(int(command["id"].(float64)))
-								moveType := command["type"].(string)
-								unit := game.getMovable(id)
-								if moveType == "aggro" {
-									unit.SetAggro(true)
-								} else {
-									fighter := game.getFighter(id)
-									if fighter != nil {
-										fighter.TargetEntityId = -1
-									}
-									unit.SetAggro(false)
-								}
-								if unit != nil {
-									unit.SetGoalPosition(pos)
-								}
-
-							case "placeBuilding":
-								pos := mapToGridLocation(command["pos"].(map[string]any))
-								switch command["type"].(string) {
-								case "house":
-									game.createHouse(pos, playerID)
-								case "townhall":
-									game.createTownHall(pos, playerID)
-								case "barracks":
-									game.createBarracks(pos, playerID)
-								default:
-									log.Printf("Invalid building type: %v", command["buildingType"])
-								}
-
-							case "createKnight":
-								salt := Float3{rand.Float64() * 10, rand.Float64() * 10, rand.Float64() * 10}
-								for _, b := range game.players[playerID].buildings {
-									if b.BuildingType == "barracks" {
-										b.Cooldown = b.MaxCooldown
-										game.players[playerID].gold -= 50
-										game.createKnight(game.players[playerID].primaryTownHall.GetPosition().add(salt), playerID)
-										break
-									}
-								}
-
-							case "createBuilder":
-								salt := Float3{rand.Float64() * 10, rand.Float64() * 10, rand.Float64() * 10}
-								for _, b := range game.players[playerID].buildings {
-									if b.BuildingType == "townhall" {
-										game.players[playerID].gold -= 50
-										b.Cooldown = b.MaxCooldown
-										game.createBuilder(game.players[playerID].primaryTownHall.GetPosition().add(salt), playerID)
-										break
-									}
-								}
-
-							default:
-								log.Printf("Invalid command type: %v", key)
-							}
-
-						} else {
-							log.Printf("Invalid command format: %v", msgTemp[i][key])
-						}
-					}
+		}
+		var msgTemp []map[string]json.RawMessage
+		if err := json.Unmarshal(message, &msgTemp); err != nil {
+			m.sendError(ws, sim.RejectCommand("", "malformed message: %v", err))
+			continue
+		}
+		m.queueCommands(ws, playerID, msgTemp)
+	}
+}
+
+// disconnect forgets a dropped connection. A player's seat is held for
+// them to come back to.
+func (m *Match) disconnect(ws *websocket.Conn, playerID sim.PlayerID) {
+	m.connMutex.Lock()
+	defer m.connMutex.Unlock()
+	delete(m.connections, ws)
+	if playerID != spectator {
+		m.leaveSeat(ws, playerID)
+	}
+}
+
+// queueCommands hands a batch of client commands to the simulation. They
+// are applied at the start of the next tick; any that cannot even be decoded
+// are reported back to the sender right away.
+func (m *Match) queueCommands(ws *websocket.Conn, playerID sim.PlayerID, msgTemp []map[string]json.RawMessage) {
+	var rejected []error
+	m.gameMutex.Lock()
+	for i := range msgTemp {
+		for _, key := range slices.Sorted(maps.Keys(msgTemp[i])) {
+			if key == "noop" || key == "ack" || key == "resync" {
+				continue
+			}
+			if playerID == spectator {
+				rejected = append(rejected, sim.RejectCommand(key, "spectators can't send commands"))
+				continue
+			}
+			log.Printf("Command %v from player %v: %s", key, playerID, msgTemp[i][key])
+			if err := m.sim.Queue(playerID, key, msgTemp[i][key]); err != nil {
+				rejected = append(rejected, err)
+			}
+		}
+	}
+	m.gameMutex.Unlock()
+
+	for i := range msgTemp {
+		for _, key := range []string{"ack", "resync"} {
+			if raw, ok := msgTemp[i][key]; ok {
+				if err := m.acknowledge(ws, key, raw); err != nil {
+					rejected = append(rejected, err)
 				}
 			}
 		}
 	}
+	for _, err := range rejected {
+		m.sendError(ws, err)
+	}
 }
 
-func broadcastGameState() {
-	for {
-		time.Sleep(10 * time.Millisecond)
-		game.update(0.05)
-		gameState := game.GetState()
-		gameStateEncoded, err := json.Marshal(gameState)
-		if err != nil {
-			log.Printf("Error marshalling JSON: %v", err)
-			continue
+// sendError tells a client that one of its commands was refused.
+func (m *Match) sendError(ws *websocket.Conn, err error) {
+	log.Printf("Rejected command: %v", err)
+	commandErr, ok := err.(*sim.CommandError)
+	if !ok {
+		commandErr = &sim.CommandError{Reason: err.Error()}
+	}
+	errorEncoded, err := json.Marshal(map[string]any{"error": commandErr})
+	if err != nil {
+		log.Printf("Error marshalling JSON: %v", err)
+		return
+	}
+
+	m.connMutex.Lock()
+	defer m.connMutex.Unlock()
+	if err := ws.WriteMessage(websocket.TextMessage, errorEncoded); err != nil {
+		log.Printf("Error writing message: %v", err)
+	}
+}
+
+// sendRejections reports commands that failed validation to every
+// connection of the player who sent them.
+func (m *Match) sendRejections(rejected []sim.Rejection) {
+	for _, r := range rejected {
+		m.connMutex.Lock()
+		var conns []*websocket.Conn
+		for conn, c := range m.connections {
+			if c.playerID == r.Player {
+				conns = append(conns, conn)
+			}
 		}
+		m.connMutex.Unlock()
+		for _, conn := range conns {
+			m.sendError(conn, r.Err)
+		}
+	}
+}
 
-		connMutex.Lock()
-		for conn := range connections {
-			err := conn.WriteMessage(websocket.TextMessage, gameStateEncoded)
-			if err != nil {
+func (m *Match) broadcastGameState() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	start := time.Now()
+	for range ticker.C {
+		due := int(time.Since(start) / tickInterval)
+		if behind := due - m.sim.Tick(); behind > maxCatchUpTicks {
+			log.Printf("Game %v is %v ticks behind, skipping ahead", m.port, behind)
+			start = start.Add(time.Duration(behind-maxCatchUpTicks) * tickInterval)
+			due = m.sim.Tick() + maxCatchUpTicks
+		}
+		m.resignAbandonedSeats()
+		for m.sim.Tick() < due {
+			m.gameMutex.Lock()
+			rejected := m.sim.Step()
+			seq := m.sim.Tick()
+			states := m.sim.Game().GetStates()
+			if m.spectatorFeed != nil {
+				if err := m.spectatorFeed.CatchUp(); err != nil {
+					log.Printf("Spectator feed of game %v: %v", m.port, err)
+				}
+				states[spectator] = m.spectatorFeed.Game().GetState()
+			}
+			m.gameMutex.Unlock()
+			m.sendRejections(rejected)
+			m.broadcast(seq, states)
+			if result := m.sim.Result(); result != nil {
+				m.finish(result)
+				return
+			}
+		}
+	}
+}
+
+// finish tells every client how the match ended, disconnects them, saves
+// the replay and shuts down the match's listener so its port is freed.
+func (m *Match) finish(result *sim.GameOver) {
+	log.Printf("Game %v is over: winners %v (%v)", m.port, result.Winners, result.Reason)
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %v", err)
+	}
+
+	m.connMutex.Lock()
+	for conn := range m.connections {
+		if encoded != nil {
+			if err := conn.WriteMessage(websocket.TextMessage, encoded); err != nil {
 				log.Printf("Error writing message: %v", err)
-				conn.Close()
-				delete(connections, conn)
 			}
 		}
-		connMutex.Unlock()
+		conn.Close()
+	}
+	m.connMutex.Unlock()
+
+	m.saveReplay()
+	if err := m.server.Shutdown(context.Background()); err != nil {
+		log.Printf("Error shutting down game %v: %v", m.port, err)
 	}
 }
 
-func initGame() {
-	log.Println("Initializing Game")
-	game = MakeTwoPlayerGame()
+// broadcast sends every connection the state for tick seq as its player
+// sees it: a delta against the last state the client is known to hold, or a
+// full snapshot if there is no such state in that player's history.
+func (m *Match) broadcast(seq int, states map[sim.PlayerID]sim.GameState) {
+	m.connMutex.Lock()
+	defer m.connMutex.Unlock()
 
-	game.createBuilder(Float3{0, .25, 0}, 1)
-	game.createBuilder(Float3{0, .25, 1}, 1)
-	game.createBuilder(Float3{0, .25, -1}, 1)
-	game.createBuilder(Float3{5, .25, 0}, 2)
-	game.createBuilder(Float3{5, .25, 1}, 2)
-	game.createBuilder(Float3{5, .25, -1}, 2)
-	game.addGold(1, 1000)
-	game.addStone(1, 1000)
-	game.addWood(1, 100)
-	game.addGold(2, 2000)
-	game.addStone(2, 2000)
-	game.addWood(2, 20000)
+	for pid, state := range states {
+		if m.histories[pid] == nil {
+			m.histories[pid] = &stateHistory{}
+		}
+		m.histories[pid].add(seq, state)
+	}
+	type update struct {
+		player  sim.PlayerID
+		baseSeq int
+	}
+	encoded := make(map[update][]byte)
+	for conn, c := range m.connections {
+		history := m.histories[c.playerID]
+		baseSeq := c.baseSeq
+		if _, ok := history.get(baseSeq); !ok {
+			baseSeq = -1
+		}
+		message, ok := encoded[update{c.playerID, baseSeq}]
+		if !ok {
+			var err error
+			message, err = encodeUpdate(history, baseSeq, seq, states[c.playerID])
+			if err != nil {
+				log.Printf("Error marshalling JSON: %v", err)
+				continue
+			}
+			encoded[update{c.playerID, baseSeq}] = message
+		}
+		err := conn.WriteMessage(websocket.TextMessage, message)
+		if err != nil {
+			log.Printf("Error writing message: %v", err)
+			conn.Close()
+			delete(m.connections, conn)
+			continue
+		}
+		if baseSeq < 0 {
+			// Until the client acknowledges something newer, the
+			// snapshot is the state it holds.
+			c.baseSeq = seq
+		}
+	}
 }
 
-func startGame(portNumber string) {
-	slashPort := fmt.Sprintf("/%v", portNumber)
-	http.HandleFunc(slashPort, handleConnections)
+func encodeUpdate(history *stateHistory, baseSeq int, seq int, gameState sim.GameState) ([]byte, error) {
+	base, ok := history.get(baseSeq)
+	if !ok {
+		return json.Marshal(Snapshot{Type: "snapshot", Seq: seq, State: gameState})
+	}
+	return json.Marshal(diffState(baseSeq, base, seq, gameState))
+}
 
-	initGame()
+type ackMessage struct {
+	Seq int `json:"seq"`
+}
 
-	go broadcastGameState()
+// acknowledge handles the client's side of the delta protocol. "ack" moves
+// the connection's baseline up to a state the client has applied, and
+// "resync" asks for a full snapshot on the next tick.
+func (m *Match) acknowledge(ws *websocket.Conn, key string, raw json.RawMessage) error {
+	var ack ackMessage
+	if key == "ack" {
+		if err := json.Unmarshal(raw, &ack); err != nil {
+			return sim.RejectCommand(key, "malformed command: %v", err)
+		}
+	}
 
-	err := http.ListenAndServe(portNumber, nil)
+	m.connMutex.Lock()
+	defer m.connMutex.Unlock()
+	c, ok := m.connections[ws]
+	if !ok {
+		return nil
+	}
+	switch key {
+	case "ack":
+		if ack.Seq > c.baseSeq {
+			c.baseSeq = ack.Seq
+		}
+	case "resync":
+		c.baseSeq = -1
+	}
+	return nil
+}
 
+func initGame(seed int64, gameMap *sim.Map, victory sim.VictoryConditions) *sim.Game {
+	log.Printf("Initializing Game with seed %v on map %v", seed, gameMap.Name)
+	return sim.NewGame(seed, gameCatalog, gameMap, victory)
+}
+
+func startGame(portNumber string, gameMap *sim.Map, victory sim.VictoryConditions, spectatorDelay float64) {
+	m, err := newMatch(portNumber, gameMap, victory, spectatorDelay)
 	if err != nil {
+		log.Printf("Failed to start game %v: %v", portNumber, err)
+		return
+	}
+	registerMatch(m)
+	defer unregisterMatch(m)
+
+	go m.broadcastGameState()
+
+	err = m.server.ListenAndServe()
+
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
+func portForGame(n int) string {
+	return fmt.Sprintf(":%v", 8080+n)
+}
+
 func enableCors(w *http.ResponseWriter) {
 	(*w).Header().Set("Access-Control-Allow-Origin", "*")
 }
 
 func getStart(w http.ResponseWriter, r *http.Request) {
 	enableCors(&w)
-	numGames++
-	portNumber := fmt.Sprintf(":%v", 8080+numGames)
+	victory, err := parseVictoryConditions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	choice, err := parseMapChoice(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	gameMap, err := sim.StartingMap(choice, mapDir, gameCatalog)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	spectatorDelay, err := parseSpectatorDelay(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	portNumber := nextMatchPort()
 
 	fmt.Printf("Got start game request")
 	//response := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\n%v", portNumber)
@@ -232,11 +383,35 @@ func getStart(w http.ResponseWriter, r *http.Request) {
 	res["data"] = portNumber
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
-	go startGame(portNumber)
+	go startGame(portNumber, gameMap, victory, spectatorDelay)
 }
 
+// gameCatalog holds the unit and building definitions every match is
+// played with.
+var gameCatalog *sim.Catalog
+
 func main() {
+	catalogPath := flag.String("catalog", "", "JSON file of unit and building definitions (defaults to the built-in catalog)")
+	flag.StringVar(&mapDir, "maps", "", "directory of JSON map files (defaults to the built-in maps)")
+	validate := flag.Bool("validate", false, "check the maps named on the command line and exit")
+	flag.StringVar(&replayDir, "replays", replayDir, "directory to save finished matches to, or empty to not record them")
+	replayPath := flag.String("replay", "", "play back a recorded match to spectators instead of hosting games")
+	flag.Parse()
+
+	var err error
+	gameCatalog, err = sim.LoadCatalog(*catalogPath)
+	if err != nil {
+		log.Fatalf("Failed to load catalog: %v", err)
+	}
+	if *validate {
+		os.Exit(validateMaps(flag.Args()))
+	}
+	if *replayPath != "" {
+		log.Fatal(runReplay(*replayPath))
+	}
+
 	http.HandleFunc("/start", getStart)
+	http.HandleFunc("/matches", getMatches)
 
 	log.Fatal(http.ListenAndServe(":8080", nil))
 
@@ -0,0 +1,183 @@
+package sim
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// defaultCatalogJSON is the built-in catalog, which LoadCatalog returns
+// when it isn't given a file.
+//
+//go:embed catalog.json
+var defaultCatalogJSON []byte
+
+// Unit kinds. A unit's kind decides which code drives it; everything else
+// about it comes from its UnitDef.
+const (
+	unitKindFighter = "fighter"
+	unitKindBuilder = "builder"
+)
+
+// A UnitDef holds the stats shared by every unit of one type.
+type UnitDef struct {
+	Kind      string  `json:"kind"`
+	Health    float64 `json:"health"`
+	Speed     float64 `json:"speed"`
+	Radius    float64 `json:"radius"`
+	Cost      Cost    `json:"cost"`
+	BuildTime float64 `json:"buildTime"`
+	Sight     float64 `json:"sight"`
+
+	// Fighters only.
+	Strength    float64 `json:"strength"`
+	AttackRange float64 `json:"attackRange"`
+	AttackDelay float64 `json:"attackDelay"`
+
+	// Builders only.
+	CarryingCapacity float64 `json:"carryingCapacity"`
+	Reach            float64 `json:"reach"`
+	MineSpeed        float64 `json:"mineSpeed"`
+}
+
+// A BuildingDef holds the stats shared by every building of one type.
+// BuildTime is seconds of a single builder's labor; Produces lists the unit
+// types the building trains. Builders can unload gathered resources at any
+// finished building with DropOff set. Sight, for units and buildings alike,
+// is how many tiles they see around them.
+type BuildingDef struct {
+	Health    float64  `json:"health"`
+	Size      int      `json:"size"`
+	Cost      Cost     `json:"cost"`
+	BuildTime float64  `json:"buildTime"`
+	Sight     float64  `json:"sight"`
+	Produces  []string `json:"produces"`
+	DropOff   bool     `json:"dropOff"`
+
+	// A building with PlacedOn set must be built over a resource node of
+	// that type, and once finished multiplies the node's gather rate by
+	// 1+YieldBonus for its owner's builders.
+	PlacedOn   string  `json:"placedOn"`
+	YieldBonus float64 `json:"yieldBonus"`
+}
+
+// A ResourceDef holds what every resource node of one type has in common.
+// GatherRate scales how fast builders mine it. A node with a RegrowthRate
+// grows back that much per second, up to Amount, and isn't removed when
+// it runs out; it can be mined again once it has fully grown back.
+type ResourceDef struct {
+	Amount       float64 `json:"amount"`
+	GatherRate   float64 `json:"gatherRate"`
+	RegrowthRate float64 `json:"regrowthRate"`
+}
+
+// resourceTypes are the resources players stockpile. The catalog has to
+// define a node type for each of them.
+var resourceTypes = []string{"gold", "stone", "wood"}
+
+// A Catalog is every unit and building type a game can contain, keyed by
+// type name.
+type Catalog struct {
+	Units     map[string]*UnitDef     `json:"units"`
+	Buildings map[string]*BuildingDef `json:"buildings"`
+	Resources map[string]*ResourceDef `json:"resources"`
+
+	maxUnitRadius float64
+}
+
+// LoadCatalog reads a catalog from path, or returns the built-in one if
+// path is empty.
+func LoadCatalog(path string) (*Catalog, error) {
+	if path == "" {
+		return parseCatalog(defaultCatalogJSON)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := parseCatalog(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return catalog, nil
+}
+
+// parseCatalog decodes and checks a catalog. Unknown fields are rejected so
+// a typo in a stat name doesn't silently leave it at zero.
+func parseCatalog(data []byte) (*Catalog, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var catalog Catalog
+	if err := decoder.Decode(&catalog); err != nil {
+		return nil, err
+	}
+	if err := catalog.validate(); err != nil {
+		return nil, err
+	}
+	for _, unit := range catalog.Units {
+		catalog.maxUnitRadius = max(catalog.maxUnitRadius, unit.Radius)
+	}
+	return &catalog, nil
+}
+
+func (c *Catalog) validate() error {
+	for name, unit := range c.Units {
+		if unit.Kind != unitKindFighter && unit.Kind != unitKindBuilder {
+			return fmt.Errorf("unit %q: unknown kind %q", name, unit.Kind)
+		}
+		if unit.Health <= 0 || unit.Speed <= 0 || unit.Radius <= 0 || unit.BuildTime <= 0 || unit.Sight <= 0 {
+			return fmt.Errorf("unit %q: health, speed, radius, buildTime and sight must be positive", name)
+		}
+		if unit.Kind == unitKindBuilder && (unit.CarryingCapacity <= 0 || unit.Reach <= 0 || unit.MineSpeed <= 0) {
+			return fmt.Errorf("unit %q: carryingCapacity, reach and mineSpeed must be positive", name)
+		}
+		if unit.Kind == unitKindFighter && (unit.AttackRange <= 0 || unit.AttackDelay <= 0) {
+			return fmt.Errorf("unit %q: attackRange and attackDelay must be positive", name)
+		}
+	}
+	if _, ok := c.Buildings["townhall"]; !ok {
+		return fmt.Errorf("no townhall building")
+	}
+	for name, building := range c.Buildings {
+		if building.Health <= 0 || building.Size <= 0 || building.BuildTime <= 0 || building.Sight <= 0 {
+			return fmt.Errorf("building %q: health, size, buildTime and sight must be positive", name)
+		}
+		for _, unitType := range building.Produces {
+			if _, ok := c.Units[unitType]; !ok {
+				return fmt.Errorf("building %q produces unknown unit %q", name, unitType)
+			}
+		}
+		if _, ok := c.Resources[building.PlacedOn]; building.PlacedOn != "" && !ok {
+			return fmt.Errorf("building %q is placed on unknown resource %q", name, building.PlacedOn)
+		}
+	}
+	for _, resourceType := range resourceTypes {
+		if _, ok := c.Resources[resourceType]; !ok {
+			return fmt.Errorf("no %v resource", resourceType)
+		}
+	}
+	for name, resource := range c.Resources {
+		if !slices.Contains(resourceTypes, name) {
+			return fmt.Errorf("unknown resource %q", name)
+		}
+		if resource.Amount <= 0 || resource.GatherRate <= 0 || resource.RegrowthRate < 0 {
+			return fmt.Errorf("resource %q: amount and gatherRate must be positive", name)
+		}
+	}
+	return nil
+}
+
+// producers lists the building types that train unitType, in name order.
+func (c *Catalog) producers(unitType string) []string {
+	var names []string
+	for name, building := range c.Buildings {
+		if slices.Contains(building.Produces, unitType) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,428 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A Command is one decoded client request. validate checks it against the
+// current game state on behalf of the sending player, and apply carries it
+// out. apply is only called after validate has passed on the same tick.
+type Command interface {
+	validate(g *Game, playerID PlayerID) error
+	apply(g *Game, playerID PlayerID)
+}
+
+// A CommandError is sent back to the player whose command was rejected.
+type CommandError struct {
+	Command string `json:"command"`
+	Reason  string `json:"reason"`
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Command, e.Reason)
+}
+
+// RejectCommand is the error for a command that can't be carried out.
+func RejectCommand(key string, format string, args ...any) *CommandError {
+	return &CommandError{Command: key, Reason: fmt.Sprintf(format, args...)}
+}
+
+type MoveTroopCommand struct {
+	ID   EntityID `json:"id"`
+	POS  Float3   `json:"pos"`
+	TYPE string   `json:"type"`
+}
+
+type PlaceBuildingCommand struct {
+	TYPE string `json:"type"`
+	POS  Float3 `json:"pos"`
+}
+
+type AttackCommand struct {
+	TARGET_ID   EntityID `json:"target_id"`
+	ATTACKER_ID EntityID `json:"attacker_id"`
+}
+
+// TrainUnitCommand queues a unit at BuildingID, or at whichever of the
+// player's producers has the shortest queue if no building is given.
+// createKnight and createBuilder are shorthands that fill in UnitType.
+type TrainUnitCommand struct {
+	UnitType   string    `json:"unitType"`
+	BuildingID *EntityID `json:"buildingId"`
+	command    string
+}
+
+type CancelProductionCommand struct {
+	BuildingID EntityID `json:"buildingId"`
+	Index      int      `json:"index"`
+}
+
+type SetRallyPointCommand struct {
+	BuildingID EntityID `json:"buildingId"`
+	POS        Float3   `json:"pos"`
+}
+
+type CancelBuildingCommand struct {
+	ID EntityID `json:"id"`
+}
+
+// GatherCommand sends a builder to mine ResourceID, or the nearest node of
+// ResourceType, or the nearest node of any type if neither is given.
+type GatherCommand struct {
+	ID           EntityID  `json:"id"`
+	ResourceID   *EntityID `json:"resourceId"`
+	ResourceType string    `json:"resourceType"`
+}
+
+// BuildCommand puts a builder to work on one of the player's unfinished
+// buildings, or to repair a finished but damaged one.
+type BuildCommand struct {
+	ID         EntityID `json:"id"`
+	BuildingID EntityID `json:"buildingId"`
+	repair     bool
+}
+
+// StopCommand cancels a builder's order and leaves it idle.
+type StopCommand struct {
+	ID EntityID `json:"id"`
+}
+
+// ResignCommand takes the player out of the game. Their units and buildings
+// stay where they are, as when they're beaten.
+type ResignCommand struct{}
+
+// validateFrom checks a command on behalf of playerID. Every command's
+// validate assumes the player is in the game, so anyone else, including
+// spectators, is refused first, and so is a player who's been beaten or
+// has resigned, whose units are left standing but no longer take orders.
+func (g *Game) validateFrom(playerID PlayerID, key string, command Command) error {
+	player, ok := g.players[playerID]
+	if !ok {
+		return RejectCommand(key, "unknown player %v", playerID)
+	}
+	if player.stats.Defeated {
+		return RejectCommand(key, "player %v is out of the game", playerID)
+	}
+	return command.validate(g, playerID)
+}
+
+// parseCommand decodes the body of a single client command. Only the shape
+// of the message is checked here; whether the player may actually do it is
+// left to validate.
+func parseCommand(key string, raw json.RawMessage) (Command, error) {
+	var command Command
+	switch key {
+	case "moveUnit":
+		command = &MoveTroopCommand{}
+	case "placeBuilding":
+		command = &PlaceBuildingCommand{}
+	case "attack":
+		command = &AttackCommand{}
+	case "cancelBuilding":
+		command = &CancelBuildingCommand{}
+	case "trainUnit":
+		command = &TrainUnitCommand{command: key}
+	case "createKnight":
+		command = &TrainUnitCommand{UnitType: "knight", command: key}
+	case "createBuilder":
+		command = &TrainUnitCommand{UnitType: "builder", command: key}
+	case "cancelProduction":
+		command = &CancelProductionCommand{}
+	case "setRallyPoint":
+		command = &SetRallyPointCommand{}
+	case "gather":
+		command = &GatherCommand{}
+	case "build":
+		command = &BuildCommand{}
+	case "repair":
+		command = &BuildCommand{repair: true}
+	case "stop":
+		command = &StopCommand{}
+	case "resign":
+		command = &ResignCommand{}
+	default:
+		return nil, RejectCommand(key, "unknown command")
+	}
+	if err := json.Unmarshal(raw, command); err != nil {
+		return nil, RejectCommand(key, "malformed command: %v", err)
+	}
+	return command, nil
+}
+
+func (g *Game) inBounds(pos Float3) bool {
+	m := g.gameMap
+	return pos.X >= float64(m.Min.X) && pos.X < float64(m.Max.X) && pos.Z >= float64(m.Min.Z) && pos.Z < float64(m.Max.Z)
+}
+
+func (c *MoveTroopCommand) validate(g *Game, playerID PlayerID) error {
+	player := g.players[playerID]
+	_, isFighter := player.fighters[c.ID]
+	_, isBuilder := player.builders[c.ID]
+	if !isFighter && !isBuilder {
+		return RejectCommand("moveUnit", "unit %v does not belong to player %v", c.ID, playerID)
+	}
+	if !g.inBounds(c.POS) {
+		return RejectCommand("moveUnit", "position %v is off the map", c.POS)
+	}
+	return nil
+}
+
+func (c *MoveTroopCommand) apply(g *Game, playerID PlayerID) {
+	unit := g.getMovable(c.ID)
+	if c.TYPE == "aggro" {
+		unit.SetAggro(true)
+	} else {
+		fighter := g.getFighter(c.ID)
+		if fighter != nil {
+			fighter.TargetEntityId = -1
+		}
+		unit.SetAggro(false)
+	}
+	unit.SetGoalPosition(c.POS)
+	if builder, ok := g.players[playerID].builders[c.ID]; ok {
+		builder.setOrder(BuilderOrder{Kind: orderMove, TargetID: -1})
+	}
+}
+
+func (c *PlaceBuildingCommand) validate(g *Game, playerID PlayerID) error {
+	def, ok := g.catalog.Buildings[c.TYPE]
+	if !ok {
+		return RejectCommand("placeBuilding", "unknown building type %q", c.TYPE)
+	}
+	if !g.inBounds(c.POS) {
+		return RejectCommand("placeBuilding", "position %v is off the map", c.POS)
+	}
+	// The site is checked against what the player's side knows of it, or
+	// placements could be used to find buildings in the fog. Anything
+	// hidden there is left to stop it in apply.
+	pos := float3ToGridLocation(c.POS)
+	side := g.players[playerID].side()
+	knowsBlocked := func(tile GridLocation) bool { return g.knowsBlocked(side, tile) }
+	if def.PlacedOn != "" {
+		if !canPlaceOnNode(g.knownResources(side), knowsBlocked, pos, def.Size, def.PlacedOn) {
+			return RejectCommand("placeBuilding", "a %v has to go over a free %v node and nothing else", c.TYPE, def.PlacedOn)
+		}
+	} else if isAreaBlocked(knowsBlocked, pos, def.Size) {
+		return RejectCommand("placeBuilding", "%v overlaps another building or resource", pos)
+	}
+	if !g.players[playerID].canAfford(&def.Cost) {
+		return RejectCommand("placeBuilding", "cannot afford a %v", c.TYPE)
+	}
+	return nil
+}
+
+// apply puts the building up if the site really is free. If something the
+// player couldn't see is in the way, nothing is built and nothing is paid.
+func (c *PlaceBuildingCommand) apply(g *Game, playerID PlayerID) {
+	def := g.catalog.Buildings[c.TYPE]
+	pos := float3ToGridLocation(c.POS)
+	if def.PlacedOn != "" {
+		if !canPlaceOnNode(g.resources, g.isBlocked, pos, def.Size, def.PlacedOn) {
+			return
+		}
+	} else if isAreaBlocked(g.isBlocked, pos, def.Size) {
+		return
+	}
+	g.createBuilding(c.TYPE, pos, playerID)
+}
+
+func (c *AttackCommand) validate(g *Game, playerID PlayerID) error {
+	if _, ok := g.players[playerID].fighters[c.ATTACKER_ID]; !ok {
+		return RejectCommand("attack", "fighter %v does not belong to player %v", c.ATTACKER_ID, playerID)
+	}
+	// Targets out of sight are as good as missing, or attacks could be
+	// used to find them.
+	if g.getKillable(c.TARGET_ID) == nil || !g.canSee(playerID, c.TARGET_ID) {
+		return RejectCommand("attack", "target %v does not exist", c.TARGET_ID)
+	}
+	if g.ownerOf(c.TARGET_ID) == playerID {
+		return RejectCommand("attack", "target %v is your own", c.TARGET_ID)
+	}
+	if g.allied(g.ownerOf(c.TARGET_ID), playerID) {
+		return RejectCommand("attack", "target %v belongs to an ally", c.TARGET_ID)
+	}
+	return nil
+}
+
+func (c *AttackCommand) apply(g *Game, playerID PlayerID) {
+	fighter := g.players[playerID].fighters[c.ATTACKER_ID]
+	fighter.TargetEntityId = c.TARGET_ID
+	fighter.SetAggro(true)
+}
+
+func (c *TrainUnitCommand) producer(g *Game, playerID PlayerID) *Building {
+	buildings := g.players[playerID].buildings
+	if c.BuildingID != nil {
+		if b, ok := buildings[*c.BuildingID]; ok && b.canProduce(c.UnitType) {
+			return b
+		}
+		return nil
+	}
+	var producer *Building
+	for _, bid := range sortedKeys(buildings) {
+		b := buildings[bid]
+		if b.canProduce(c.UnitType) && (producer == nil || len(b.Queue) < len(producer.Queue)) {
+			producer = b
+		}
+	}
+	return producer
+}
+
+func (c *TrainUnitCommand) validate(g *Game, playerID PlayerID) error {
+	def, ok := g.catalog.Units[c.UnitType]
+	if !ok {
+		return RejectCommand(c.command, "unknown unit type %q", c.UnitType)
+	}
+	producer := c.producer(g, playerID)
+	if producer == nil {
+		if c.BuildingID != nil {
+			return RejectCommand(c.command, "building %v can't train a %v", *c.BuildingID, c.UnitType)
+		}
+		return RejectCommand(c.command, "no %v to train a %v", strings.Join(g.catalog.producers(c.UnitType), " or "), c.UnitType)
+	}
+	if len(producer.Queue) >= productionQueueLimit {
+		return RejectCommand(c.command, "the queue at building %v is full", producer.Id)
+	}
+	if !g.players[playerID].canAfford(&def.Cost) {
+		return RejectCommand(c.command, "cannot afford a %v", c.UnitType)
+	}
+	return nil
+}
+
+func (c *TrainUnitCommand) apply(g *Game, playerID PlayerID) {
+	g.enqueueUnit(c.producer(g, playerID), c.UnitType, playerID)
+}
+
+func (c *CancelProductionCommand) validate(g *Game, playerID PlayerID) error {
+	building, ok := g.players[playerID].buildings[c.BuildingID]
+	if !ok {
+		return RejectCommand("cancelProduction", "building %v does not belong to player %v", c.BuildingID, playerID)
+	}
+	if c.Index < 0 || c.Index >= len(building.Queue) {
+		return RejectCommand("cancelProduction", "building %v has no queue entry %v", c.BuildingID, c.Index)
+	}
+	return nil
+}
+
+func (c *CancelProductionCommand) apply(g *Game, playerID PlayerID) {
+	g.cancelUnit(g.players[playerID].buildings[c.BuildingID], c.Index, playerID)
+}
+
+func (c *SetRallyPointCommand) validate(g *Game, playerID PlayerID) error {
+	if _, ok := g.players[playerID].buildings[c.BuildingID]; !ok {
+		return RejectCommand("setRallyPoint", "building %v does not belong to player %v", c.BuildingID, playerID)
+	}
+	if !g.inBounds(c.POS) {
+		return RejectCommand("setRallyPoint", "position %v is off the map", c.POS)
+	}
+	return nil
+}
+
+func (c *SetRallyPointCommand) apply(g *Game, playerID PlayerID) {
+	pos := c.POS
+	g.players[playerID].buildings[c.BuildingID].RallyPoint = &pos
+}
+
+func (c *CancelBuildingCommand) validate(g *Game, playerID PlayerID) error {
+	building, ok := g.players[playerID].buildings[c.ID]
+	if !ok {
+		return RejectCommand("cancelBuilding", "building %v does not belong to player %v", c.ID, playerID)
+	}
+	if building.IsComplete() {
+		return RejectCommand("cancelBuilding", "building %v is already finished", c.ID)
+	}
+	return nil
+}
+
+func (c *CancelBuildingCommand) apply(g *Game, playerID PlayerID) {
+	g.cancelConstruction(g.players[playerID].buildings[c.ID], playerID)
+}
+
+func (c *GatherCommand) validate(g *Game, playerID PlayerID) error {
+	if _, ok := g.players[playerID].builders[c.ID]; !ok {
+		return RejectCommand("gather", "builder %v does not belong to player %v", c.ID, playerID)
+	}
+	// Nodes are known by what the player's side has seen of them, so one
+	// that's been mined out in the fog can still be ordered. The builder
+	// goes for the nearest node of the same type instead, as it would
+	// have once the node ran out.
+	if c.ResourceID != nil {
+		if _, ok := g.visionOf(playerID).resources[*c.ResourceID]; !ok {
+			return RejectCommand("gather", "resource %v does not exist", *c.ResourceID)
+		}
+		return nil
+	}
+	switch c.ResourceType {
+	case "", "gold", "stone", "wood":
+		return nil
+	}
+	return RejectCommand("gather", "unknown resource type %q", c.ResourceType)
+}
+
+func (c *GatherCommand) apply(g *Game, playerID PlayerID) {
+	order := gatherOrder(c.ResourceType)
+	if c.ResourceID != nil {
+		order.TargetID = *c.ResourceID
+		order.ResourceType = g.visionOf(playerID).resources[*c.ResourceID].ResourceType
+	}
+	g.players[playerID].builders[c.ID].setOrder(order)
+}
+
+func (c *BuildCommand) key() string {
+	if c.repair {
+		return "repair"
+	}
+	return "build"
+}
+
+func (c *BuildCommand) validate(g *Game, playerID PlayerID) error {
+	player := g.players[playerID]
+	if _, ok := player.builders[c.ID]; !ok {
+		return RejectCommand(c.key(), "builder %v does not belong to player %v", c.ID, playerID)
+	}
+	building, ok := player.buildings[c.BuildingID]
+	if !ok {
+		return RejectCommand(c.key(), "building %v does not belong to player %v", c.BuildingID, playerID)
+	}
+	if c.repair && !building.IsComplete() {
+		return RejectCommand(c.key(), "building %v is still under construction", c.BuildingID)
+	}
+	if c.repair && building.Health >= building.MaxHealth {
+		return RejectCommand(c.key(), "building %v isn't damaged", c.BuildingID)
+	}
+	if !c.repair && building.IsComplete() {
+		return RejectCommand(c.key(), "building %v is already finished", c.BuildingID)
+	}
+	return nil
+}
+
+func (c *BuildCommand) apply(g *Game, playerID PlayerID) {
+	kind := orderBuild
+	if c.repair {
+		kind = orderRepair
+	}
+	g.players[playerID].builders[c.ID].setOrder(BuilderOrder{Kind: kind, TargetID: c.BuildingID})
+}
+
+func (c *StopCommand) validate(g *Game, playerID PlayerID) error {
+	if _, ok := g.players[playerID].builders[c.ID]; !ok {
+		return RejectCommand("stop", "builder %v does not belong to player %v", c.ID, playerID)
+	}
+	return nil
+}
+
+func (c *StopCommand) apply(g *Game, playerID PlayerID) {
+	builder := g.players[playerID].builders[c.ID]
+	builder.setOrder(idleOrder)
+	builder.finishOrder()
+}
+
+func (c *ResignCommand) validate(g *Game, playerID PlayerID) error {
+	return nil
+}
+
+func (c *ResignCommand) apply(g *Game, playerID PlayerID) {
+	g.players[playerID].stats.Defeated = true
+}
@@ -0,0 +1,234 @@
+package sim_test
+
+import (
+	"strings"
+	"testing"
+
+	"hackcu2025/sim"
+)
+
+// A skirmish is a small two player match laid out by hand. Player 1 has
+// a knight scouting player 2's town hall and a builder at home. Player 2
+// has a builder working gold by their town hall, where player 1's knight
+// can see it, and a knight out of sight in the far corner, by a gold node
+// nobody has claimed yet.
+type skirmish struct {
+	s *sim.Simulation
+
+	homeHall, scout, homeBuilder sim.EntityID
+	enemyHall, enemyBuilder      sim.EntityID
+	hiddenKnight                 sim.EntityID
+	nearGold                     sim.EntityID
+}
+
+func newSkirmish(t *testing.T) *skirmish {
+	t.Helper()
+	catalog, err := sim.LoadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &sim.Map{
+		Name: "skirmish",
+		Max:  sim.GridLocation{X: 60, Z: 60},
+		Bases: []sim.MapBase{
+			{
+				Player:    1,
+				TownHall:  sim.GridLocation{X: 5, Z: 5},
+				Units:     []sim.MapUnit{{Type: "knight", Position: sim.GridLocation{X: 30, Z: 12}}, {Type: "builder", Position: sim.GridLocation{X: 9, Z: 5}}},
+				Resources: sim.Cost{Gold: 40, Stone: 150, Wood: 100},
+			},
+			{
+				Player:    2,
+				TownHall:  sim.GridLocation{X: 30, Z: 5},
+				Units:     []sim.MapUnit{{Type: "builder", Position: sim.GridLocation{X: 34, Z: 9}}, {Type: "knight", Position: sim.GridLocation{X: 50, Z: 50}}},
+				Resources: sim.Cost{Gold: 500, Stone: 500, Wood: 500},
+			},
+		},
+		Resources: []sim.MapResource{
+			{Type: "gold", Position: sim.GridLocation{X: 10, Z: 10}},
+			{Type: "gold", Position: sim.GridLocation{X: 36, Z: 8}},
+			{Type: "gold", Position: sim.GridLocation{X: 50, Z: 40}},
+		},
+	}
+	s := sim.NewSimulation(sim.NewGame(1, catalog, m, sim.VictoryConditions{TownHalls: true}))
+	// One tick so that everyone has looked around.
+	s.Step()
+
+	k := &skirmish{s: s}
+	state := s.Game().GetState()
+	home, enemy := state.Players[1], state.Players[2]
+	k.homeHall, k.enemyHall = home.PrimaryTownHall, enemy.PrimaryTownHall
+	for id := range home.Fighters {
+		k.scout = id
+	}
+	for id := range home.Builders {
+		k.homeBuilder = id
+	}
+	for id := range enemy.Builders {
+		k.enemyBuilder = id
+	}
+	for id := range enemy.Fighters {
+		k.hiddenKnight = id
+	}
+	for id, resource := range state.Resources {
+		if resource.Position == (sim.GridLocation{X: 10, Z: 10}) {
+			k.nearGold = id
+		}
+	}
+	return k
+}
+
+// step runs one tick and returns why each command queued for it was
+// rejected, or "" for the ones that went through.
+func (k *skirmish) step(t *testing.T, queued int) []string {
+	t.Helper()
+	reasons := make([]string, queued)
+	for _, rejection := range k.s.Step() {
+		reasons[rejection.Index] = rejection.Err.Error()
+	}
+	return reasons
+}
+
+func TestCommandValidation(t *testing.T) {
+	at := func(x, z float64) sim.Float3 { return sim.Float3{X: x, Z: z} }
+	missing := sim.EntityID(9999)
+	tests := []struct {
+		name   string
+		player sim.PlayerID
+		key    string
+		args   func(k *skirmish) any
+		reason string
+	}{
+		{"move own unit", 1, "moveUnit", func(k *skirmish) any {
+			return sim.MoveTroopCommand{ID: k.scout, POS: at(20, 20)}
+		}, ""},
+		{"move someone else's unit", 1, "moveUnit", func(k *skirmish) any {
+			return sim.MoveTroopCommand{ID: k.enemyBuilder, POS: at(20, 20)}
+		}, "does not belong to player 1"},
+		{"move off the map", 1, "moveUnit", func(k *skirmish) any {
+			return sim.MoveTroopCommand{ID: k.scout, POS: at(-5, 20)}
+		}, "off the map"},
+		{"place off the map", 2, "placeBuilding", func(k *skirmish) any {
+			return sim.PlaceBuildingCommand{TYPE: "house", POS: at(20, 80)}
+		}, "off the map"},
+		{"place over a building", 2, "placeBuilding", func(k *skirmish) any {
+			return sim.PlaceBuildingCommand{TYPE: "house", POS: at(31, 6)}
+		}, "overlaps another building"},
+		{"place something unaffordable", 1, "placeBuilding", func(k *skirmish) any {
+			return sim.PlaceBuildingCommand{TYPE: "barracks", POS: at(20, 20)}
+		}, "cannot afford a barracks"},
+		{"place something affordable", 2, "placeBuilding", func(k *skirmish) any {
+			return sim.PlaceBuildingCommand{TYPE: "house", POS: at(40, 20)}
+		}, ""},
+		{"train at someone else's building", 1, "trainUnit", func(k *skirmish) any {
+			return sim.TrainUnitCommand{UnitType: "builder", BuildingID: &k.enemyHall}
+		}, "can't train a builder"},
+		{"train something unaffordable", 1, "trainUnit", func(k *skirmish) any {
+			return sim.TrainUnitCommand{UnitType: "builder"}
+		}, "cannot afford a builder"},
+		{"train something affordable", 2, "trainUnit", func(k *skirmish) any {
+			return sim.TrainUnitCommand{UnitType: "builder"}
+		}, ""},
+		{"gather for someone else", 1, "gather", func(k *skirmish) any {
+			return sim.GatherCommand{ID: k.enemyBuilder, ResourceID: &k.nearGold}
+		}, "does not belong to player 1"},
+		{"gather from a missing node", 1, "gather", func(k *skirmish) any {
+			return sim.GatherCommand{ID: k.homeBuilder, ResourceID: &missing}
+		}, "does not exist"},
+		{"attack in sight", 1, "attack", func(k *skirmish) any {
+			return sim.AttackCommand{ATTACKER_ID: k.scout, TARGET_ID: k.enemyBuilder}
+		}, ""},
+		{"attack out of sight", 1, "attack", func(k *skirmish) any {
+			return sim.AttackCommand{ATTACKER_ID: k.scout, TARGET_ID: k.hiddenKnight}
+		}, "does not exist"},
+		{"attack with someone else's unit", 2, "attack", func(k *skirmish) any {
+			return sim.AttackCommand{ATTACKER_ID: k.scout, TARGET_ID: k.enemyBuilder}
+		}, "does not belong to player 2"},
+		{"unknown player", 7, "moveUnit", func(k *skirmish) any {
+			return sim.MoveTroopCommand{ID: k.scout, POS: at(20, 20)}
+		}, "unknown player 7"},
+		{"spectator", 0, "moveUnit", func(k *skirmish) any {
+			return sim.MoveTroopCommand{ID: k.scout, POS: at(20, 20)}
+		}, "unknown player 0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k := newSkirmish(t)
+			queue(t, k.s, test.player, test.key, test.args(k))
+			got := k.step(t, 1)[0]
+			if test.reason == "" && got != "" {
+				t.Fatalf("rejected: %v", got)
+			}
+			if !strings.Contains(got, test.reason) {
+				t.Fatalf("rejected with %q, want %q", got, test.reason)
+			}
+		})
+	}
+}
+
+// Placing a building over one the player can't see can't be how they find
+// it: the command goes through, and only then does the site turn out to
+// be taken, so nothing is built and nothing is paid.
+func TestPlacementDoesNotRevealHiddenBuildings(t *testing.T) {
+	k := newSkirmish(t)
+	farGold := sim.Float3{X: 50, Z: 40}
+	queue(t, k.s, 2, "placeBuilding", sim.PlaceBuildingCommand{TYPE: "mine", POS: farGold})
+	if reasons := k.step(t, 1); reasons[0] != "" {
+		t.Fatalf("player 2 couldn't place their mine: %v", reasons[0])
+	}
+	queue(t, k.s, 1, "placeBuilding", sim.PlaceBuildingCommand{TYPE: "mine", POS: farGold})
+	before := k.s.Game().GetState().Players[1]
+	if reasons := k.step(t, 1); reasons[0] != "" {
+		t.Fatalf("placing over a hidden building was rejected: %v", reasons[0])
+	}
+	after := k.s.Game().GetState().Players[1]
+	if len(after.Buildings) != len(before.Buildings) || after.Gold != before.Gold {
+		t.Fatalf("player 1 built over player 2's mine")
+	}
+}
+
+func TestStateForHidesEnemyPlans(t *testing.T) {
+	k := newSkirmish(t)
+	queue(t, k.s, 2, "trainUnit", sim.TrainUnitCommand{UnitType: "builder"})
+	queue(t, k.s, 2, "moveUnit", sim.MoveTroopCommand{ID: k.hiddenKnight, POS: sim.Float3{X: 55, Z: 30}})
+	if reasons := k.step(t, 2); reasons[0] != "" || reasons[1] != "" {
+		t.Fatalf("player 2's commands were rejected: %v", reasons)
+	}
+
+	g := k.s.Game()
+	full := g.GetState()
+	if len(full.Players[2].Buildings[k.enemyHall].Queue) == 0 {
+		t.Fatalf("player 2 isn't training anything")
+	}
+	if full.Players[2].Builders[k.enemyBuilder].Order.TargetID < 0 {
+		t.Fatalf("player 2's builder isn't gathering")
+	}
+
+	mine := g.StateFor(full, 1)
+	enemy := mine.Players[2]
+	if enemy.Gold != 0 || enemy.Stone != 0 || enemy.Wood != 0 {
+		t.Errorf("player 1 can see player 2's stockpile: %v gold, %v stone, %v wood", enemy.Gold, enemy.Stone, enemy.Wood)
+	}
+	hall, ok := enemy.Buildings[k.enemyHall]
+	if !ok {
+		t.Fatalf("player 1's scout can't see player 2's town hall")
+	}
+	if hall.Queue != nil || hall.RallyPoint != nil {
+		t.Errorf("player 1 can see player 2's production: %+v", hall)
+	}
+	builder, ok := enemy.Builders[k.enemyBuilder]
+	if !ok {
+		t.Fatalf("player 1's scout can't see player 2's builder")
+	}
+	if builder.Order.TargetID != -1 || builder.ResourceTarget != nil || builder.GoalPosition != builder.Position {
+		t.Errorf("player 1 can see what player 2's builder is doing: %+v", builder)
+	}
+	if _, ok := enemy.Fighters[k.hiddenKnight]; ok {
+		t.Errorf("player 1 can see player 2's knight across the map")
+	}
+
+	// Nothing of player 1's own is hidden from them.
+	if got, want := mine.Players[1].Gold, full.Players[1].Gold; got != want {
+		t.Errorf("player 1 sees %v gold of their own, but has %v", got, want)
+	}
+}
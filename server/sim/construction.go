@@ -0,0 +1,92 @@
+package sim
+
+// Building.Progress runs from 0 when a foundation is placed up to
+// buildingComplete, at which point the building starts working.
+const buildingComplete = 100
+
+// A foundation starts with this fraction of its maximum health and gains
+// the rest as it goes up.
+const foundationHealth = 0.1
+
+// Builders within this distance of a new foundation are put to work on it.
+const constructionAssignRadius = 15
+
+// constructionRefund is the share of a building's cost given back when its
+// construction is cancelled.
+const constructionRefund = 0.75
+
+// IsComplete reports whether a building has finished construction.
+func (b *Building) IsComplete() bool {
+	return b.Progress >= buildingComplete
+}
+
+// startConstruction turns a freshly created building into a foundation
+// and assigns the owner's nearby builders to it, leaving alone any that
+// were given some other job. If none are close by, the closest free builder
+// is sent instead.
+func (g *Game) startConstruction(building *Building, playerId PlayerID) {
+	building.Progress = 0
+	building.Health = building.MaxHealth * foundationHealth
+
+	player := g.players[playerId]
+	position := building.GetPosition()
+	order := BuilderOrder{Kind: orderBuild, TargetID: building.Id}
+	assigned := false
+	for _, e := range g.unitIndex.around(position, constructionAssignRadius) {
+		if builder, ok := player.builders[e.id]; ok && builder.isFree() {
+			builder.setOrder(order)
+			assigned = true
+		}
+	}
+	if assigned {
+		return
+	}
+	closest, found := g.unitIndex.nearest(position, func(e spatialEntry) bool {
+		builder, ok := player.builders[e.id]
+		return ok && builder.isFree()
+	})
+	if found {
+		player.builders[closest.id].setOrder(order)
+	}
+}
+
+// construct has a builder walk to its construction site and put in dt
+// seconds of work once it's there. It reports false if the builder has
+// nothing to build.
+func (g *Game) construct(builder *Builder, player *Player, dt float64) bool {
+	building, ok := player.buildings[builder.Order.TargetID]
+	if !ok || building.IsComplete() {
+		return false
+	}
+
+	builder.GoalPosition = building.GetPosition()
+	if building.distanceTo(builder.Position) >= builder.def.Reach {
+		return true
+	}
+
+	// BuildTime is for one builder; several on one site add up.
+	work := dt / building.def.BuildTime
+	building.Progress = min(building.Progress+work*buildingComplete, buildingComplete)
+	building.SetHealth(building.Health + work*building.MaxHealth*(1-foundationHealth))
+	if building.IsComplete() {
+		player.stats.BuildingsBuilt++
+		if _, ok := player.stats.FirstBuilt[building.BuildingType]; !ok {
+			player.stats.FirstBuilt[building.BuildingType] = g.elapsedTime
+		}
+		if player.primaryTownHall == nil {
+			player.primaryTownHall = g.nextTownHall(player)
+		}
+	}
+	return true
+}
+
+// cancelConstruction tears down an unfinished building and refunds part of
+// its cost.
+func (g *Game) cancelConstruction(building *Building, playerId PlayerID) {
+	player := g.players[playerId]
+	player.gold += building.Cost.Gold * constructionRefund
+	player.stone += building.Cost.Stone * constructionRefund
+	player.wood += building.Cost.Wood * constructionRefund
+	g.deleteEntity(building.Id)
+	g.pendingDeceased = append(g.pendingDeceased, building.Id)
+}
@@ -0,0 +1,383 @@
+package sim
+
+import "slices"
+
+type Movable interface {
+	GetGoalPosition() Float3
+	SetGoalPosition(Float3)
+	GetPosition() Float3
+	SetPosition(Float3)
+	GetSpeed() float64
+	SetAggro(bool)
+	getPath() *navPath
+	getRadius() float64
+}
+
+type Killable interface {
+	GetHealth() float64
+	SetHealth(float64)
+	GetPosition() Float3
+	distanceTo(Float3) float64
+}
+
+func (g *Game) updateMovable(m Movable, dt float64) {
+	// Move movable entity along its path towards goal location
+	g.updatePath(m)
+	path := m.getPath()
+	position := m.GetPosition()
+	distanceToMove := m.GetSpeed() * dt
+	for len(path.waypoints) > 0 && distanceToMove > 0 {
+		waypoint := path.waypoints[0]
+		delta := waypoint.subtract(position)
+		if delta.length() <= distanceToMove {
+			// We've hit the waypoint
+			distanceToMove -= delta.length()
+			position = waypoint
+			path.waypoints = path.waypoints[1:]
+		} else {
+			// We're getting closer to the waypoint
+			delta_norm := delta.normalize()
+			moveVector := delta_norm.scale(distanceToMove)
+			position = position.add(moveVector)
+			distanceToMove = 0
+		}
+	}
+	m.SetPosition(position)
+}
+
+const aggroRadius float64 = 10
+
+type Fighter struct {
+	Id                 EntityID `json:"id"`
+	UnitType           string   `json:"unitType"`
+	Position           Float3   `json:"position"`
+	GoalPosition       Float3   `json:"goalPosition"`
+	TargetEntityId     EntityID `json:"targetEntityId"`
+	Aggro              bool     `json:"aggro"`
+	Strength           float64  `json:"strength"`
+	Speed              float64  `json:"speed"`
+	TimeTillNextAttack float64  `json:"timeTillNextAttack"`
+	AreaOfAttack       float64  `json:"areaOfAttack"`
+	AttackDelay        float64  `json:"attackSpeed"`
+	MaxHealth          float64  `json:"maxHealth"`
+	Health             float64  `json:"health"`
+	path               navPath
+	def                *UnitDef
+}
+
+// createUnit adds a unit of any type in the catalog for a player.
+func (g *Game) createUnit(unitType string, position Float3, id PlayerID) Movable {
+	def := g.catalog.Units[unitType]
+	if def.Kind == unitKindBuilder {
+		return g.createBuilder(unitType, def, position, id)
+	}
+	return g.createFighter(unitType, def, position, id)
+}
+
+func (g *Game) createFighter(unitType string, def *UnitDef, position Float3, id PlayerID) *Fighter {
+	entityId := g.newEntityID()
+
+	fighter := &Fighter{
+		Id:                 entityId,
+		UnitType:           unitType,
+		Position:           position,
+		GoalPosition:       position,
+		Strength:           def.Strength,
+		AreaOfAttack:       def.AttackRange,
+		AttackDelay:        def.AttackDelay,
+		Aggro:              false,
+		TimeTillNextAttack: 0,
+		TargetEntityId:     -1,
+		Speed:              def.Speed,
+		Health:             def.Health,
+		MaxHealth:          def.Health,
+		def:                def,
+	}
+	g.players[id].fighters[entityId] = fighter
+	g.unitIndex.insert(entityId, id, position, fighter.getRadius())
+	return fighter
+}
+
+func (g *Game) getFighter(id EntityID) *Fighter {
+	for _, player := range g.players {
+		if fighter, ok := player.fighters[id]; ok {
+			return fighter
+		}
+	}
+	return nil
+}
+
+// snapshot copies the fighter for a GameState. Nothing in the copy shares
+// memory with the live fighter, and the route it's following is left out.
+func (f *Fighter) snapshot() Fighter {
+	state := *f
+	state.path = navPath{}
+	return state
+}
+
+func (f *Fighter) GetPosition() Float3 {
+	return f.Position
+}
+
+func (f *Fighter) GetSpeed() float64 {
+	return f.Speed
+}
+
+func (f *Fighter) GetGoalPosition() Float3 {
+	return f.GoalPosition
+}
+
+func (f *Fighter) SetPosition(p Float3) {
+	f.Position = p
+}
+
+// SetGoalPosition sends the fighter to p. The route there is planned
+// around buildings and resource nodes before the fighter next moves.
+func (f *Fighter) SetGoalPosition(p Float3) {
+	f.GoalPosition = p
+}
+
+func (f *Fighter) getPath() *navPath {
+	return &f.path
+}
+
+func (f *Fighter) getRadius() float64 {
+	return f.def.Radius
+}
+
+func (f *Fighter) distanceTo(p Float3) float64 {
+	return f.Position.subtract(p).length()
+}
+
+func (f *Fighter) GetHealth() float64 {
+	return f.Health
+}
+
+func (f *Fighter) SetAggro(a bool) {
+	f.Aggro = a
+}
+
+func (f *Fighter) SetHealth(h float64) {
+	if h > f.MaxHealth {
+		h = f.MaxHealth
+	}
+	f.Health = h
+}
+
+type Builder struct {
+	Id             EntityID  `json:"id"`
+	Position       Float3    `json:"position"`
+	UnitType       string    `json:"unitType"`
+	GoalPosition   Float3    `json:"goalPosition"`
+	Gold           float64   `json:"gold"`
+	Stone          float64   `json:"stone"`
+	Wood           float64   `json:"wood"`
+	Aggro          bool      `json:"aggro"`
+	Health         float64   `json:"health"`
+	MaxHealth      float64   `json:"max_health"`
+	ResourceTarget *Resource `json:"resource_target"`
+
+	// What the builder is doing, and the gather order it goes back to
+	// once a build or repair order is done.
+	Order  BuilderOrder `json:"order"`
+	resume BuilderOrder
+	path   navPath
+	def    *UnitDef
+}
+
+func (g *Game) createBuilder(unitType string, def *UnitDef, position Float3, id PlayerID) *Builder {
+	entityId := g.newEntityID()
+	builder := &Builder{
+		Id:           entityId,
+		UnitType:     unitType,
+		Position:     position,
+		GoalPosition: position,
+		Gold:         0,
+		Stone:        0,
+		Wood:         0,
+		Aggro:        false,
+		Health:       def.Health,
+		MaxHealth:    def.Health,
+
+		Order:  idleOrder,
+		resume: idleOrder,
+		def:    def,
+	}
+	g.players[id].builders[entityId] = builder
+	g.unitIndex.insert(entityId, id, position, builder.getRadius())
+	return builder
+}
+
+// snapshot copies the builder for a GameState. Nothing in the copy shares
+// memory with the live builder, and the route it's following is left out.
+func (b *Builder) snapshot() Builder {
+	state := *b
+	state.path = navPath{}
+	if b.ResourceTarget != nil {
+		target := *b.ResourceTarget
+		state.ResourceTarget = &target
+	}
+	return state
+}
+
+func (b *Builder) GetPosition() Float3 {
+	return b.Position
+}
+
+func (b *Builder) GetSpeed() float64 {
+	return b.def.Speed
+}
+
+func (b *Builder) GetGoalPosition() Float3 {
+	return b.GoalPosition
+}
+
+func (b *Builder) SetPosition(p Float3) {
+	b.Position = p
+}
+
+// SetGoalPosition sends the builder to p. The route there is planned
+// around buildings and resource nodes before the builder next moves.
+func (b *Builder) SetGoalPosition(p Float3) {
+	b.GoalPosition = p
+}
+
+func (b *Builder) getPath() *navPath {
+	return &b.path
+}
+
+func (b *Builder) getRadius() float64 {
+	return b.def.Radius
+}
+
+func (b *Builder) distanceTo(p Float3) float64 {
+	return b.Position.subtract(p).length()
+}
+
+func (b *Builder) GetHealth() float64 {
+	return b.Health
+}
+
+func (b *Builder) SetAggro(a bool) {
+	b.Aggro = a
+}
+
+func (b *Builder) SetHealth(h float64) {
+	if h > b.MaxHealth {
+		h = b.MaxHealth
+	}
+	b.Health = h
+}
+
+// Building types
+// house, townhall, barracks, mine
+type Cost struct {
+	Gold  float64 `json:"gold"`
+	Stone float64 `json:"stone"`
+	Wood  float64 `json:"wood"`
+}
+type Building struct {
+	Id           EntityID     `json:"id"`
+	BuildingType string       `json:"buildingType"`
+	Position     GridLocation `json:"position"`
+	// Size         Float3      `json:"size"`
+	Cost        Cost    `json:"cost"`
+	MaxHealth   float64 `json:"maxHealth"`
+	Health      float64 `json:"health"`
+	Progress    float64 `json:"progress"`
+	Cooldown    float64 `json:"cooldown"`
+	MaxCooldown float64 `json:"maxCooldown"`
+	// Units waiting to be trained here, and where they go once they're out.
+	Queue      []ProductionItem `json:"queue"`
+	RallyPoint *Float3          `json:"rallyPoint"`
+	def        *BuildingDef
+}
+
+// snapshot copies the building for a GameState. Nothing in the copy shares
+// memory with the live building.
+func (b *Building) snapshot() Building {
+	state := *b
+	state.Queue = slices.Clone(b.Queue)
+	if b.RallyPoint != nil {
+		rally := *b.RallyPoint
+		state.RallyPoint = &rally
+	}
+	return state
+}
+
+func (b *Building) GetHealth() float64 {
+	return b.Health
+}
+
+func (b *Building) SetHealth(h float64) {
+	if h > b.MaxHealth {
+		h = b.MaxHealth
+	}
+	b.Health = h
+}
+
+func (b *Building) GetPosition() Float3 {
+	return Float3{X: float64(b.Position.X), Y: 0, Z: float64(b.Position.Z)}
+}
+
+func (p *Player) canAfford(cost *Cost) bool {
+	return p.gold >= cost.Gold && p.stone >= cost.Stone && p.wood >= cost.Wood
+}
+
+func (p *Player) payCost(cost *Cost) {
+	p.gold -= cost.Gold
+	p.stone -= cost.Stone
+	p.wood -= cost.Wood
+}
+
+// createBuilding charges the player for a building of any type in the
+// catalog and lays its foundation.
+func (g *Game) createBuilding(buildingType string, position GridLocation, playerId PlayerID) *Building {
+	def := g.catalog.Buildings[buildingType]
+	cost := def.Cost
+	player := g.players[playerId]
+	if !player.canAfford(&cost) {
+		return nil
+	}
+	player.payCost(&cost)
+
+	entityId := g.newEntityID()
+	building := &Building{
+		Id:           entityId,
+		BuildingType: buildingType,
+		Position:     position,
+		Cost:         cost,
+		MaxHealth:    def.Health,
+		Health:       def.Health,
+		Progress:     0,
+		Cooldown:     0,
+		MaxCooldown:  0,
+		def:          def,
+	}
+	g.players[playerId].buildings[entityId] = building
+	g.unitIndex.insert(entityId, playerId, building.GetPosition(), 0)
+	g.setBlocked(position, building.size(), 1)
+	if def.PlacedOn != "" {
+		nodeUnder(g.resources, position, def.Size, def.PlacedOn).MineID = entityId
+	}
+	g.startConstruction(building, playerId)
+	return building
+}
+
+// Resource types
+// 'gold', 'stone', 'wood'
+type Resource struct {
+	Id           EntityID     `json:"id"`
+	ResourceType string       `json:"resourceType"`
+	Position     GridLocation `json:"position"`
+	Amount       float64      `json:"amount"`
+	// Set while a regrowing node that ran out grows back.
+	Depleted bool `json:"depleted"`
+	// The mine built over this node, or -1.
+	MineID EntityID `json:"mineId"`
+	def    *ResourceDef
+}
+
+func (r *Resource) GetPosition() Float3 {
+	return r.Position.toFloat3()
+}
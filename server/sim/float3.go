@@ -1,9 +1,8 @@
-package main
+package sim
 
 import (
 	"encoding/json"
 	"math"
-	"math/rand"
 )
 
 type Float3 struct {
@@ -12,13 +11,6 @@ type Float3 struct {
 	Z float64 `json:"z"`
 }
 
-func getFloat3Noise(max float64) Float3 {
-	x := max * (rand.Float64()*2 - 1)
-	y := max * (rand.Float64()*2 - 1)
-	z := max * (rand.Float64()*2 - 1)
-	return Float3{x, y, z}
-}
-
 func float3FromGridLocation(loc GridLocation) Float3 {
 	return Float3{
 		X: float64(loc.X),
@@ -27,14 +19,6 @@ func float3FromGridLocation(loc GridLocation) Float3 {
 	}
 }
 
-func mapToFloat3(m map[string]any) Float3 {
-	return Float3{
-		X: m["x"].(float64),
-		Y: m["y"].(float64),
-		Z: m["z"].(float64),
-	}
-}
-
 func (grid GridLocation) toFloat3() Float3 {
 	return Float3{
 		X: float64(grid.X),
@@ -0,0 +1,493 @@
+package sim
+
+import (
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+type GridLocation struct {
+	X int `json:"x"`
+	Z int `json:"z"`
+}
+
+func float3ToGridLocation(p Float3) GridLocation {
+	return GridLocation{
+		X: int(math.Floor(p.X)),
+		Z: int(math.Floor(p.Z)),
+	}
+}
+
+// Players are numbered from 1. Spectators, who aren't players, are 0.
+type PlayerID int
+
+// Every unit, building and resource node has an EntityID, unique within
+// its game.
+type EntityID int
+
+type Player struct {
+	id              int
+	team            int
+	gold            float64
+	stone           float64
+	wood            float64
+	primaryTownHall *Building
+	fighters        map[EntityID]*Fighter
+	builders        map[EntityID]*Builder
+	buildings       map[EntityID]*Building
+	stats           PlayerStats
+}
+
+func (g *Game) createPlayer(id int, townHallLoc GridLocation) Player {
+	townHallId := g.newEntityID()
+	def := g.catalog.Buildings["townhall"]
+	townHall := &Building{
+		Id:           townHallId,
+		BuildingType: "townhall",
+		Position:     townHallLoc,
+		Cost:         Cost{0, 0, 0},
+		MaxHealth:    def.Health,
+		Health:       def.Health,
+		Progress:     buildingComplete,
+		Cooldown:     0,
+		MaxCooldown:  0,
+		def:          def,
+	}
+
+	buildings := make(map[EntityID]*Building)
+	buildings[townHallId] = townHall
+
+	p := Player{
+		id:              id,
+		gold:            0,
+		stone:           0,
+		wood:            0,
+		fighters:        make(map[EntityID]*Fighter),
+		builders:        make(map[EntityID]*Builder),
+		buildings:       buildings,
+		primaryTownHall: townHall,
+		stats:           PlayerStats{FirstBuilt: make(map[string]float64)},
+	}
+
+	g.players[PlayerID(id)] = &p
+	g.unitIndex.insert(townHallId, PlayerID(id), townHall.GetPosition(), 0)
+	g.setBlocked(townHallLoc, townHall.size(), 1)
+	return p
+}
+
+// A Game is the whole state of one match. It only changes through the
+// Simulation running it; GetState, GetStates and StateFor read it.
+type Game struct {
+	seed        int64
+	rng         *rand.Rand
+	catalog     *Catalog
+	gameMap     *Map
+	victory     VictoryConditions
+	tick        int
+	elapsedTime float64
+	deceased    []EntityID
+	players     map[PlayerID]*Player
+	resources   map[EntityID]*Resource
+	entityIDs   map[EntityID]struct{}
+	blocked     map[GridLocation]int
+	// Counts changes to blocked, so paths planned before the last one can
+	// be told apart.
+	blockedVersion int
+
+	// Entities removed outside of combat, such as cancelled buildings,
+	// to be reported as deceased at the end of the tick.
+	pendingDeceased []EntityID
+
+	// Resource nodes that ran out or grew back this tick.
+	events []ResourceEvent
+
+	// What each side can see, keyed by side.
+	visions map[int]*vision
+
+	// Set once the match is decided; the game stops advancing after that.
+	over *GameOver
+
+	// Spatial indexes over resource nodes and over everything players own
+	// (fighters, builders and buildings), kept in step with entity
+	// positions as they spawn, move and die.
+	resourceIndex *spatialGrid
+	unitIndex     *spatialGrid
+}
+
+// A GameState is the game as clients see it at the end of a tick.
+type GameState struct {
+	ElapsedTime float64                  `json:"elapsedTime"`
+	Deceased    []EntityID               `json:"deceased"`
+	Events      []ResourceEvent          `json:"events"`
+	Players     map[PlayerID]PlayerState `json:"players"`
+	Resources   map[EntityID]Resource    `json:"resources"`
+}
+
+// A PlayerState is one player's part of a GameState.
+type PlayerState struct {
+	Id        int                   `json:"id"`
+	Gold      float64               `json:"gold"`
+	Stone     float64               `json:"stone"`
+	Wood      float64               `json:"wood"`
+	Fighters  map[EntityID]Fighter  `json:"fighters"`
+	Builders  map[EntityID]Builder  `json:"builders"`
+	Buildings map[EntityID]Building `json:"buildings"`
+
+	// The player's main town hall, or -1 once they have none left.
+	PrimaryTownHall EntityID `json:"primaryTownHall"`
+	Team            int      `json:"team"`
+}
+
+// GetState is the whole game as it stands, with nothing hidden.
+func (g *Game) GetState() GameState {
+	state := GameState{}
+	state.ElapsedTime = g.elapsedTime
+	state.Deceased = g.deceased
+	state.Events = g.events
+	state.Players = make(map[PlayerID]PlayerState)
+	state.Resources = make(map[EntityID]Resource)
+
+	for eid, resource := range g.resources {
+		state.Resources[eid] = *resource
+	}
+
+	for pid, player := range g.players {
+		fighters := make(map[EntityID]Fighter)
+		builders := make(map[EntityID]Builder)
+		buildings := make(map[EntityID]Building)
+		for fid, fighter := range player.fighters {
+			fighters[fid] = fighter.snapshot()
+		}
+		for bid, builder := range player.builders {
+			builders[bid] = builder.snapshot()
+		}
+		for bid, building := range player.buildings {
+			buildings[bid] = building.snapshot()
+		}
+
+		primaryTownHall := EntityID(-1)
+		if player.primaryTownHall != nil {
+			primaryTownHall = player.primaryTownHall.Id
+		}
+		state.Players[pid] = PlayerState{
+			Id:        player.id,
+			Gold:      player.gold,
+			Stone:     player.stone,
+			Wood:      player.wood,
+			Fighters:  fighters,
+			Builders:  builders,
+			Buildings: buildings,
+
+			PrimaryTownHall: primaryTownHall,
+			Team:            player.team,
+		}
+	}
+	return state
+}
+
+// NewGame sets up a game on m, to be won as victory says: every base's town
+// hall, starting units and stockpile, and the map's resource nodes and
+// terrain. Starting builders get straight to work on whatever is nearest.
+func NewGame(seed int64, catalog *Catalog, m *Map, victory VictoryConditions) *Game {
+	g := &Game{
+		seed:        seed,
+		rng:         rand.New(rand.NewSource(seed)),
+		catalog:     catalog,
+		gameMap:     m,
+		victory:     victory,
+		elapsedTime: 0,
+		players:     make(map[PlayerID]*Player),
+		entityIDs:   make(map[EntityID]struct{}),
+		blocked:     make(map[GridLocation]int),
+		resources:   make(map[EntityID]*Resource),
+
+		resourceIndex: newSpatialGrid(),
+		unitIndex:     newSpatialGrid(),
+	}
+	for _, base := range m.Bases {
+		g.createPlayer(int(base.Player), base.TownHall)
+		g.players[base.Player].team = base.Team
+		g.addGold(base.Player, base.Resources.Gold)
+		g.addStone(base.Player, base.Resources.Stone)
+		g.addWood(base.Player, base.Resources.Wood)
+		for _, unit := range base.Units {
+			created := g.createUnit(unit.Type, tileCenter(unit.Position, .25), base.Player)
+			if builder, ok := created.(*Builder); ok {
+				builder.setOrder(gatherOrder(""))
+			}
+		}
+	}
+	for _, resource := range m.Resources {
+		node := g.createResource(resource.Type, resource.Position)
+		if resource.Amount > 0 {
+			node.Amount = resource.Amount
+		}
+	}
+	for _, terrain := range m.Terrain {
+		// setBlocked takes an anchor, which sits one tile up from the
+		// tile a size 1 footprint covers.
+		g.setBlocked(GridLocation{terrain.Position.X + 1, terrain.Position.Z + 1}, 1, 1)
+	}
+	g.visions = make(map[int]*vision)
+	for _, player := range g.players {
+		if _, ok := g.visions[player.side()]; !ok {
+			g.visions[player.side()] = g.newVision()
+		}
+	}
+	g.updateVisions()
+	return g
+}
+
+// Map is the map the game is played on.
+func (g *Game) Map() *Map {
+	return g.gameMap
+}
+
+// sortedKeys returns the keys of m in ascending order. The simulation walks
+// its maps through this so every run visits entities in the same order.
+func sortedKeys[K ~int, V any](m map[K]V) []K {
+	return slices.Sorted(maps.Keys(m))
+}
+
+func (g *Game) newEntityID() EntityID {
+	for {
+		proposedId := EntityID(g.rng.Intn(1 << 20))
+		_, exists := g.entityIDs[proposedId]
+		if !exists {
+			g.entityIDs[proposedId] = struct{}{}
+			return proposedId
+		}
+	}
+}
+
+func (g *Game) deleteEntity(id EntityID) {
+	delete(g.entityIDs, id)
+	for pid, _ := range g.players {
+		if building, ok := g.players[pid].buildings[id]; ok {
+			g.setBlocked(building.Position, building.size(), -1)
+		}
+		delete(g.players[pid].builders, id)
+		delete(g.players[pid].fighters, id)
+		delete(g.players[pid].buildings, id)
+	}
+
+	if resource, ok := g.resources[id]; ok {
+		g.setBlocked(resource.Position, resourceSize, -1)
+	}
+	for _, resource := range g.resources {
+		if resource.MineID == id {
+			resource.MineID = -1
+		}
+	}
+	delete(g.resources, id)
+	g.resourceIndex.remove(id)
+	g.unitIndex.remove(id)
+}
+
+// nextTownHall picks the town hall that becomes a player's primary one
+// when theirs is destroyed: the finished one with the lowest ID, or nil if
+// there is none.
+func (g *Game) nextTownHall(player *Player) *Building {
+	for _, bid := range sortedKeys(player.buildings) {
+		building := player.buildings[bid]
+		if building.BuildingType == "townhall" && building.IsComplete() {
+			return building
+		}
+	}
+	return nil
+}
+
+// nearestDropOff returns the player's closest finished building that takes
+// resources, or nil if there is none.
+func (g *Game) nearestDropOff(player *Player, position Float3) *Building {
+	entry, found := g.unitIndex.nearest(position, func(e spatialEntry) bool {
+		building, ok := player.buildings[e.id]
+		return ok && building.def.DropOff && building.IsComplete()
+	})
+	if !found {
+		return nil
+	}
+	return player.buildings[entry.id]
+}
+
+// update advances the game by dt seconds. It reports false once the match
+// is over, after which it does nothing.
+func (g *Game) update(dt float64) bool {
+	if g.over != nil {
+		return false
+	}
+	g.tick++
+	g.elapsedTime += dt
+	g.events = nil
+	for _, pid := range sortedKeys(g.players) {
+		player := g.players[pid]
+		for _, fid := range sortedKeys(player.fighters) {
+			fighter := player.fighters[fid]
+			g.updateMovable(fighter, dt)
+			g.separate(fighter.Id, fighter, dt)
+			g.unitIndex.move(fighter.Id, fighter.Position)
+			if fighter.TargetEntityId != -1 {
+				fighter.huntDown(g, dt)
+			} else {
+				if len(fighter.path.waypoints) == 0 || fighter.Aggro {
+					fighter.generalAttack(g, PlayerID(player.id), dt)
+				}
+			}
+		}
+		for _, bid := range sortedKeys(player.builders) {
+			builder := player.builders[bid]
+			g.updateMovable(builder, dt)
+			g.separate(builder.Id, builder, dt)
+			g.unitIndex.move(builder.Id, builder.Position)
+			g.updateBuilder(builder, player, dt)
+		}
+		for _, bid := range sortedKeys(player.buildings) {
+			g.updateProduction(player.buildings[bid], pid, dt)
+		}
+	}
+	g.regrowResources(dt)
+	g.getDeceased()
+	g.updateVisions()
+	g.checkVictory()
+	return g.over == nil
+}
+
+func (g *Game) getClosestEnemy(f *Fighter, playerId PlayerID) EntityID {
+	enemy, found := g.unitIndex.within(f.Position, aggroRadius, func(e spatialEntry) bool {
+		return !g.allied(e.owner, playerId) && g.canSee(playerId, e.id)
+	})
+	if !found {
+		return -1
+	}
+	return enemy.id
+}
+
+func (g *Game) getKillable(id EntityID) Killable {
+	for _, player := range g.players {
+		if fighter, exists := player.fighters[id]; exists {
+			return fighter
+		}
+		if builder, exists := player.builders[id]; exists {
+			return builder
+		}
+		if building, exists := player.buildings[id]; exists {
+			return building
+		}
+	}
+	return nil
+}
+
+func (f *Fighter) huntDown(g *Game, dt float64) {
+	// IDs are reused, so a target that's gone may come back as someone
+	// else's, or as an ally's. Nor can a fighter follow a target out of its
+	// side's sight.
+	target := g.getKillable(f.TargetEntityId)
+	owner := g.ownerOf(f.Id)
+	if target == nil || g.allied(owner, g.ownerOf(f.TargetEntityId)) || !g.canSee(owner, f.TargetEntityId) {
+		f.TargetEntityId = -1
+		return
+	}
+	if target.GetHealth() <= 0 {
+		f.TargetEntityId = -1
+		return
+	}
+	if target.distanceTo(f.Position) <= f.AreaOfAttack {
+		if f.TimeTillNextAttack <= 0 {
+			target.SetHealth(target.GetHealth() - f.Strength)
+			f.TimeTillNextAttack = f.AttackDelay
+			if target.GetHealth() <= 0 {
+				g.creditKill(g.ownerOf(f.Id), target)
+				f.TargetEntityId = -1
+			}
+		}
+
+	} else {
+		f.SetGoalPosition(f.approachPoint(target))
+	}
+	f.TimeTillNextAttack -= dt
+}
+
+func (f *Fighter) generalAttack(g *Game, playerId PlayerID, dt float64) {
+	closestEnemy := g.getClosestEnemy(f, playerId)
+	if closestEnemy < 0 {
+		return
+	}
+	f.TargetEntityId = closestEnemy
+	f.huntDown(g, dt)
+}
+
+// ownerOf returns the player owning a unit or building, or 0 for resources
+// and unknown IDs.
+func (g *Game) ownerOf(id EntityID) PlayerID {
+	for pid, player := range g.players {
+		_, isFighter := player.fighters[id]
+		_, isBuilder := player.builders[id]
+		_, isBuilding := player.buildings[id]
+		if isFighter || isBuilder || isBuilding {
+			return pid
+		}
+	}
+	return 0
+}
+
+func (g *Game) getMovable(id EntityID) Movable {
+	for _, player := range g.players {
+		fighter, exists := player.fighters[id]
+		if exists {
+			return fighter
+		}
+		builder, exists := player.builders[id]
+		if exists {
+			return builder
+		}
+	}
+	return nil
+}
+
+func (g *Game) getDeceased() {
+	deceased := append([]EntityID{}, g.pendingDeceased...)
+	g.pendingDeceased = nil
+	for _, pid := range sortedKeys(g.players) {
+		player := g.players[pid]
+		for _, fid := range sortedKeys(player.fighters) {
+			fighter := player.fighters[fid]
+			if fighter.Health <= 0 {
+				player.stats.UnitsLost++
+				deceased = append(deceased, fighter.Id)
+				g.deleteEntity(fighter.Id)
+			}
+		}
+		for _, bid := range sortedKeys(player.builders) {
+			builder := player.builders[bid]
+			if builder.Health <= 0 {
+				player.stats.UnitsLost++
+				deceased = append(deceased, builder.Id)
+				g.deleteEntity(builder.Id)
+			}
+		}
+		for _, bid := range sortedKeys(player.buildings) {
+			building := player.buildings[bid]
+			if building.Health <= 0 {
+				player.stats.BuildingsLost++
+				deceased = append(deceased, building.Id)
+				g.deleteEntity(building.Id)
+			}
+		}
+		if player.primaryTownHall != nil && player.buildings[player.primaryTownHall.Id] == nil {
+			player.primaryTownHall = g.nextTownHall(player)
+		}
+	}
+	g.deceased = deceased
+
+}
+
+func (g *Game) addGold(player PlayerID, amount float64) {
+	g.players[player].gold += amount
+}
+func (g *Game) addStone(player PlayerID, amount float64) {
+	g.players[player].stone += amount
+}
+func (g *Game) addWood(player PlayerID, amount float64) {
+	g.players[player].wood += amount
+}
@@ -0,0 +1,3 @@
+module hackcu2025/sim
+
+go 1.24.0
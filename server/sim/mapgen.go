@@ -0,0 +1,328 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Map symmetries. Rotational maps work for any number of players; mirrored
+// maps reflect the first player's quarter or half of the map across the
+// axes, so they only work for 2 or 4 players.
+const (
+	symmetryRotational = "rotational"
+	symmetryMirror     = "mirror"
+)
+
+const (
+	minPlayers = 2
+	maxPlayers = 8
+)
+
+// MapOptions control GenerateMap. Every count is per player.
+type MapOptions struct {
+	Seed     int64  `json:"seed"`
+	Players  int    `json:"players"`
+	Symmetry string `json:"symmetry"`
+	// Half the width of the square map, in tiles.
+	Size int `json:"size"`
+	// How far from the center of the map the town halls are, as a
+	// fraction of Size.
+	BaseDistance float64 `json:"baseDistance"`
+	// Nothing but the town hall goes within BaseClearance tiles of its
+	// center, so there is room to build around it. Terrain keeps twice
+	// that distance.
+	BaseClearance float64 `json:"baseClearance"`
+	// Each base gets one cluster of every resource type between
+	// BaseClearance and BaseClusterDistance from its town hall.
+	BaseClusterDistance float64 `json:"baseClusterDistance"`
+	NeutralClusters     int     `json:"neutralClusters"`
+	NodesPerCluster     int     `json:"nodesPerCluster"`
+	TerrainPatches      int     `json:"terrainPatches"`
+	TilesPerPatch       int     `json:"tilesPerPatch"`
+
+	// What every player starts with. Starting builders wait just outside
+	// the town hall, on the side facing the middle of the map.
+	StartingBuilders  int  `json:"startingBuilders"`
+	StartingResources Cost `json:"startingResources"`
+}
+
+var defaultMapOptions = MapOptions{
+	Players:             2,
+	Symmetry:            symmetryRotational,
+	Size:                100,
+	BaseDistance:        0.6,
+	BaseClearance:       6,
+	BaseClusterDistance: 16,
+	NeutralClusters:     6,
+	NodesPerCluster:     6,
+	TerrainPatches:      4,
+	TilesPerPatch:       10,
+	StartingBuilders:    3,
+	StartingResources:   Cost{Gold: 1000, Stone: 1000, Wood: 500},
+}
+
+// DefaultMapOptions are what maps are normally generated with, for two
+// players. The seed is left for the caller to choose.
+func DefaultMapOptions() MapOptions {
+	return defaultMapOptions
+}
+
+// maxPlacementTries bounds how many random spots the generator tries for
+// any one feature before giving up on it.
+const maxPlacementTries = 50
+
+type mapGenerator struct {
+	options MapOptions
+	catalog *Catalog
+	rng     *rand.Rand
+	m       *Map
+	// Centers of the town halls, in player order.
+	bases []Float3
+	taken map[GridLocation]bool
+}
+
+// GenerateMap builds a map from options, leaving room for the catalog's
+// town hall. The same options always give the same map. Everything is laid
+// out around the first player's base and then copied to every other base
+// through the map's symmetry, so no player starts with better ground than
+// another.
+func GenerateMap(catalog *Catalog, options MapOptions) (*Map, error) {
+	if options.Players < minPlayers || options.Players > maxPlayers {
+		return nil, fmt.Errorf("maps hold %v to %v players, not %v", minPlayers, maxPlayers, options.Players)
+	}
+	switch options.Symmetry {
+	case symmetryRotational:
+	case symmetryMirror:
+		if options.Players != 2 && options.Players != 4 {
+			return nil, fmt.Errorf("mirrored maps need 2 or 4 players, not %v", options.Players)
+		}
+	default:
+		return nil, fmt.Errorf("unknown symmetry %q", options.Symmetry)
+	}
+	if options.Size < 20 {
+		return nil, fmt.Errorf("map size %v is too small", options.Size)
+	}
+
+	gen := &mapGenerator{
+		options: options,
+		catalog: catalog,
+		rng:     rand.New(rand.NewSource(options.Seed)),
+		m: &Map{
+			Name: fmt.Sprintf("generated-%v", options.Seed),
+			Seed: options.Seed,
+			Min:  GridLocation{-options.Size, -options.Size},
+			Max:  GridLocation{options.Size, options.Size},
+		},
+		taken: make(map[GridLocation]bool),
+	}
+	gen.placeBases()
+	for _, resourceType := range resourceTypes {
+		gen.placeBaseCluster(resourceType)
+	}
+	for range options.NeutralClusters {
+		gen.placeNeutralCluster()
+	}
+	for range options.TerrainPatches {
+		gen.placeTerrainPatch()
+	}
+	if err := gen.m.validate(catalog); err != nil {
+		return nil, fmt.Errorf("generated a bad map: %w", err)
+	}
+	return gen.m, nil
+}
+
+// transform maps a point in the first player's part of the map to the
+// matching point in player i's part.
+func (gen *mapGenerator) transform(p Float3, i int) Float3 {
+	if gen.options.Symmetry == symmetryMirror {
+		if i&1 != 0 {
+			p.Z = -p.Z
+		}
+		if i&2 != 0 {
+			p.X = -p.X
+		}
+		return p
+	}
+	angle := 2 * math.Pi * float64(i) / float64(gen.options.Players)
+	sin, cos := math.Sincos(angle)
+	return Float3{X: p.X*cos - p.Z*sin, Z: p.X*sin + p.Z*cos}
+}
+
+// images returns the tile and its copy in every other player's part of the
+// map, or false if any copy is off the map or lands on another.
+func (gen *mapGenerator) images(tile GridLocation) ([]GridLocation, bool) {
+	var images []GridLocation
+	seen := make(map[GridLocation]bool)
+	for i := range gen.options.Players {
+		image := float3ToGridLocation(gen.transform(tileCenter(tile, 0), i))
+		if seen[image] || !gen.onMap(image) {
+			return nil, false
+		}
+		seen[image] = true
+		images = append(images, image)
+	}
+	return images, true
+}
+
+// onMap keeps features one tile in from the edge, so units can walk
+// around them.
+func (gen *mapGenerator) onMap(tile GridLocation) bool {
+	return tile.X > gen.m.Min.X && tile.X < gen.m.Max.X-1 && tile.Z > gen.m.Min.Z && tile.Z < gen.m.Max.Z-1
+}
+
+// isClear reports whether tile is free and at least clearance away from
+// every town hall.
+func (gen *mapGenerator) isClear(tile GridLocation, clearance float64) bool {
+	if gen.taken[tile] {
+		return false
+	}
+	for _, base := range gen.bases {
+		if tileCenter(tile, 0).subtract(base).length() < clearance {
+			return false
+		}
+	}
+	return true
+}
+
+// claim takes a tile and all its copies if every one of them is clear.
+func (gen *mapGenerator) claim(tile GridLocation, clearance float64) ([]GridLocation, bool) {
+	images, ok := gen.images(tile)
+	if !ok {
+		return nil, false
+	}
+	for _, image := range images {
+		if !gen.isClear(image, clearance) {
+			return nil, false
+		}
+	}
+	for _, image := range images {
+		gen.taken[image] = true
+	}
+	return images, true
+}
+
+func (gen *mapGenerator) placeBases() {
+	distance := gen.options.BaseDistance * float64(gen.options.Size)
+	first := Float3{X: 0, Z: -distance}
+	if gen.options.Symmetry == symmetryMirror && gen.options.Players == 4 {
+		first = Float3{X: -distance, Z: -distance}.scale(1 / math.Sqrt2)
+	}
+	size := float64(gen.catalog.Buildings["townhall"].Size)
+	for i := range gen.options.Players {
+		// Snap the center to a tile corner so the footprint lines up
+		// with the grid.
+		center := gen.transform(first, i)
+		center = Float3{X: math.Round(center.X), Z: math.Round(center.Z)}
+		gen.bases = append(gen.bases, center)
+
+		low := float3ToGridLocation(center.subtract(Float3{X: size / 2, Z: size / 2}))
+		anchor := GridLocation{low.X + 1, low.Z + 1}
+		footprintLow, footprintHigh := footprint(anchor, int(size))
+		for x := footprintLow.X; x <= footprintHigh.X; x++ {
+			for z := footprintLow.Z; z <= footprintHigh.Z; z++ {
+				gen.taken[GridLocation{x, z}] = true
+			}
+		}
+
+		// Just far enough toward the middle to clear the corners of the
+		// town hall.
+		exit := float3ToGridLocation(center.subtract(center.normalize().scale(size/2 + 1.5)))
+		gen.taken[exit] = true
+		base := MapBase{Player: PlayerID(i + 1), TownHall: anchor, Resources: gen.options.StartingResources}
+		for range gen.options.StartingBuilders {
+			base.Units = append(base.Units, MapUnit{Type: "builder", Position: exit})
+		}
+		gen.m.Bases = append(gen.m.Bases, base)
+	}
+}
+
+// placeCluster scatters up to NodesPerCluster nodes of one type around
+// center, with a copy for every player.
+func (gen *mapGenerator) placeCluster(resourceType string, center Float3) {
+	placed := 0
+	for try := 0; try < maxPlacementTries && placed < gen.options.NodesPerCluster; try++ {
+		offset := Float3{X: gen.rng.Float64()*5 - 2.5, Z: gen.rng.Float64()*5 - 2.5}
+		images, ok := gen.claim(float3ToGridLocation(center.add(offset)), gen.options.BaseClearance)
+		if !ok {
+			continue
+		}
+		for _, image := range images {
+			// A resource node covers the tile just below its anchor.
+			anchor := GridLocation{image.X + 1, image.Z + 1}
+			gen.m.Resources = append(gen.m.Resources, MapResource{Type: resourceType, Position: anchor})
+		}
+		placed++
+	}
+}
+
+// placeBaseCluster puts a cluster of one resource type close to the first
+// player's town hall, and so close to everyone's.
+func (gen *mapGenerator) placeBaseCluster(resourceType string) {
+	base := gen.bases[0]
+	clearance := gen.options.BaseClearance + 2
+	for range maxPlacementTries {
+		angle := gen.rng.Float64() * 2 * math.Pi
+		distance := clearance + gen.rng.Float64()*(gen.options.BaseClusterDistance-clearance)
+		center := base.add(Float3{X: math.Cos(angle) * distance, Z: math.Sin(angle) * distance})
+		if gen.ownedBy(center) == 0 {
+			gen.placeCluster(resourceType, center)
+			return
+		}
+	}
+}
+
+// placeNeutralCluster puts a cluster of a random type somewhere in the
+// first player's part of the map.
+func (gen *mapGenerator) placeNeutralCluster() {
+	roll := gen.rng.Float64()
+	resourceType := "wood"
+	if roll < 0.3 {
+		resourceType = "gold"
+	} else if roll < 0.6 {
+		resourceType = "stone"
+	}
+	gen.placeCluster(resourceType, gen.randomPoint())
+}
+
+// placeTerrainPatch grows a blob of rock or water from a random point in
+// the first player's part of the map.
+func (gen *mapGenerator) placeTerrainPatch() {
+	terrainType := "rock"
+	if gen.rng.Intn(2) == 0 {
+		terrainType = "water"
+	}
+	tile := float3ToGridLocation(gen.randomPoint())
+	for range gen.options.TilesPerPatch {
+		if images, ok := gen.claim(tile, 2*gen.options.BaseClearance); ok {
+			for _, image := range images {
+				gen.m.Terrain = append(gen.m.Terrain, TerrainTile{Type: terrainType, Position: image})
+			}
+		}
+		step := pathSteps[gen.rng.Intn(4)]
+		tile = GridLocation{tile.X + step.X, tile.Z + step.Z}
+	}
+}
+
+// randomPoint picks a point in the first player's part of the map: the
+// points closer to their town hall than to anyone else's.
+func (gen *mapGenerator) randomPoint() Float3 {
+	size := float64(gen.options.Size)
+	for {
+		p := Float3{X: (gen.rng.Float64()*2 - 1) * size, Z: (gen.rng.Float64()*2 - 1) * size}
+		if gen.ownedBy(p) == 0 {
+			return p
+		}
+	}
+}
+
+// ownedBy returns the index of the town hall closest to p.
+func (gen *mapGenerator) ownedBy(p Float3) int {
+	closest := 0
+	for i, base := range gen.bases {
+		if p.subtract(base).length() < p.subtract(gen.bases[closest]).length() {
+			closest = i
+		}
+	}
+	return closest
+}
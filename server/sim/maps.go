@@ -0,0 +1,309 @@
+package sim
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A Map is everything a game starts from: the playable area, where each
+// player's town hall goes and what they start with, the resource nodes and
+// the impassable terrain. Hand-authored maps are JSON files of this shape;
+// generated ones serialize the same way.
+type Map struct {
+	Name string `json:"name"`
+	Seed int64  `json:"seed,omitempty"`
+	// The playable tiles run from Min up to but not including Max.
+	Min       GridLocation  `json:"min"`
+	Max       GridLocation  `json:"max"`
+	Bases     []MapBase     `json:"bases"`
+	Resources []MapResource `json:"resources"`
+	Terrain   []TerrainTile `json:"terrain"`
+}
+
+// A MapBase is where one player starts. TownHall is the town hall's anchor,
+// as for any building. Bases with the same nonzero Team are allies.
+type MapBase struct {
+	Player   PlayerID     `json:"player"`
+	Team     int          `json:"team,omitempty"`
+	TownHall GridLocation `json:"townHall"`
+
+	// The player's starting units and stockpile. Starting builders go
+	// straight to gathering.
+	Units     []MapUnit `json:"units"`
+	Resources Cost      `json:"resources"`
+}
+
+// A MapUnit is a starting unit, placed in the middle of Position's tile.
+type MapUnit struct {
+	Type     string       `json:"type"`
+	Position GridLocation `json:"position"`
+}
+
+// A MapResource is a resource node. Amount overrides the catalog's amount
+// for the node's type when it's set.
+type MapResource struct {
+	Type     string       `json:"type"`
+	Position GridLocation `json:"position"`
+	Amount   float64      `json:"amount,omitempty"`
+}
+
+// A TerrainTile is one impassable tile, such as rock or water.
+type TerrainTile struct {
+	Type     string       `json:"type"`
+	Position GridLocation `json:"position"`
+}
+
+// builtinMaps are the maps the game ships with. They're what LoadMap and
+// MapNames use when they aren't given a directory.
+//
+//go:embed maps/*.json
+var builtinMaps embed.FS
+
+// Map names are file names without the .json, and may not reach outside
+// the map directory.
+var mapNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// LoadMap reads the named map from dir, or from the built-in maps if dir
+// is empty, and checks it against the catalog.
+func LoadMap(dir string, name string, catalog *Catalog) (*Map, error) {
+	if !mapNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("bad map name %q", name)
+	}
+	var data []byte
+	var err error
+	if dir == "" {
+		data, err = builtinMaps.ReadFile("maps/" + name + ".json")
+	} else {
+		data, err = os.ReadFile(filepath.Join(dir, name+".json"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no map %q", name)
+	}
+	m, err := ParseMap(data, catalog)
+	if err != nil {
+		return nil, fmt.Errorf("map %q: %w", name, err)
+	}
+	if m.Name == "" {
+		m.Name = name
+	}
+	return m, nil
+}
+
+// A MapChoice is what a match is played on.
+type MapChoice struct {
+	// The map file to load, or empty to generate a map from Options.
+	Name    string
+	Options MapOptions
+	// One team per player in base order, or nil to leave the map's own
+	// teams in place.
+	Teams []int
+}
+
+// StartingMap loads or generates the chosen map, looking for map files in
+// dir, and assigns the chosen teams.
+func StartingMap(choice MapChoice, dir string, catalog *Catalog) (*Map, error) {
+	var m *Map
+	var err error
+	if choice.Name != "" {
+		m, err = LoadMap(dir, choice.Name, catalog)
+	} else {
+		m, err = GenerateMap(catalog, choice.Options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTeams(choice.Teams, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MapNames lists the maps LoadMap can find in dir, in name order.
+func MapNames(dir string) ([]string, error) {
+	var files []string
+	var err error
+	if dir == "" {
+		files, err = fs.Glob(builtinMaps, "maps/*.json")
+	} else {
+		files, err = filepath.Glob(filepath.Join(dir, "*.json"))
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+	return names, err
+}
+
+// ParseMap decodes and validates a map. Like the catalog, unknown fields
+// are rejected.
+func ParseMap(data []byte, catalog *Catalog) (*Map, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var m Map
+	if err := decoder.Decode(&m); err != nil {
+		return nil, err
+	}
+	if err := m.validate(catalog); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// validate reports everything wrong with the map at once: bad player
+// numbers, unknown types, anything off the map, entities on top of each
+// other, and bases or starting units that can't be walked to from the
+// first base.
+func (m *Map) validate(catalog *Catalog) error {
+	var problems []error
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if m.Min.X >= m.Max.X || m.Min.Z >= m.Max.Z {
+		return fmt.Errorf("min %v is not below max %v", m.Min, m.Max)
+	}
+	if len(m.Bases) < minPlayers || len(m.Bases) > maxPlayers {
+		report("maps hold %v to %v players, not %v", minPlayers, maxPlayers, len(m.Bases))
+	}
+	players := make(map[PlayerID]bool)
+	for _, base := range m.Bases {
+		if base.Player < 1 || int(base.Player) > len(m.Bases) || players[base.Player] {
+			report("bases must be numbered 1 to %v once each, found player %v", len(m.Bases), base.Player)
+		}
+		if base.Team < 0 || base.Team > maxPlayers {
+			report("player %v is on team %v, not 0 to %v", base.Player, base.Team, maxPlayers)
+		}
+		players[base.Player] = true
+	}
+
+	// Every tile something stands on, and what it is, to catch overlaps.
+	occupied := make(map[GridLocation]string)
+	occupy := func(tile GridLocation, what string) {
+		if !m.contains(tile) {
+			report("%v is off the map at %v", what, tile)
+		} else if other, ok := occupied[tile]; ok {
+			report("%v overlaps %v at %v", what, other, tile)
+		}
+		occupied[tile] = what
+	}
+
+	townHallSize := catalog.Buildings["townhall"].Size
+	for _, base := range m.Bases {
+		low, high := footprint(base.TownHall, townHallSize)
+		for x := low.X; x <= high.X; x++ {
+			for z := low.Z; z <= high.Z; z++ {
+				occupy(GridLocation{x, z}, fmt.Sprintf("player %v's town hall", base.Player))
+			}
+		}
+		if base.Resources.Gold < 0 || base.Resources.Stone < 0 || base.Resources.Wood < 0 {
+			report("player %v starts with negative resources", base.Player)
+		}
+	}
+	for _, resource := range m.Resources {
+		what := fmt.Sprintf("%v node at %v", resource.Type, resource.Position)
+		if _, ok := catalog.Resources[resource.Type]; !ok {
+			report("unknown resource type in %v", what)
+		}
+		if resource.Amount < 0 {
+			report("%v has a negative amount", what)
+		}
+		tile, _ := footprint(resource.Position, resourceSize)
+		occupy(tile, what)
+	}
+	for _, terrain := range m.Terrain {
+		if terrain.Type == "" {
+			report("terrain at %v has no type", terrain.Position)
+		}
+		occupy(terrain.Position, fmt.Sprintf("%v at %v", terrain.Type, terrain.Position))
+	}
+
+	// Units don't block tiles, so they only need to stand on open ground
+	// that connects to everyone else.
+	if len(m.Bases) > 0 {
+		reachable := m.reachableFrom(m.Bases[0], townHallSize, occupied)
+		for _, base := range m.Bases[1:] {
+			if !m.baseReachable(base, townHallSize, reachable) {
+				report("player %v's base can't be reached from player %v's", base.Player, m.Bases[0].Player)
+			}
+		}
+		for _, base := range m.Bases {
+			for _, unit := range base.Units {
+				what := fmt.Sprintf("player %v's %v at %v", base.Player, unit.Type, unit.Position)
+				if _, ok := catalog.Units[unit.Type]; !ok {
+					report("unknown unit type for %v", what)
+				}
+				if other, ok := occupied[unit.Position]; ok {
+					report("%v is on top of %v", what, other)
+				} else if !reachable[unit.Position] {
+					report("%v is off the map or walled in", what)
+				}
+			}
+		}
+	}
+	return errors.Join(problems...)
+}
+
+func (m *Map) contains(tile GridLocation) bool {
+	return tile.X >= m.Min.X && tile.X < m.Max.X && tile.Z >= m.Min.Z && tile.Z < m.Max.Z
+}
+
+// ring returns the tiles just outside a base's town hall.
+func ring(base MapBase, size int) []GridLocation {
+	low, high := footprint(base.TownHall, size)
+	var tiles []GridLocation
+	for x := low.X - 1; x <= high.X+1; x++ {
+		for z := low.Z - 1; z <= high.Z+1; z++ {
+			if x < low.X || x > high.X || z < low.Z || z > high.Z {
+				tiles = append(tiles, GridLocation{x, z})
+			}
+		}
+	}
+	return tiles
+}
+
+// reachableFrom flood fills the open tiles of the map from around a base.
+// It only takes straight steps, so a diagonal gap between two blocked
+// tiles doesn't count as a way through.
+func (m *Map) reachableFrom(base MapBase, size int, occupied map[GridLocation]string) map[GridLocation]bool {
+	reachable := make(map[GridLocation]bool)
+	var frontier []GridLocation
+	visit := func(tile GridLocation) {
+		if reachable[tile] || !m.contains(tile) {
+			return
+		}
+		if _, ok := occupied[tile]; ok {
+			return
+		}
+		reachable[tile] = true
+		frontier = append(frontier, tile)
+	}
+	for _, tile := range ring(base, size) {
+		visit(tile)
+	}
+	for len(frontier) > 0 {
+		tile := frontier[len(frontier)-1]
+		frontier = frontier[:len(frontier)-1]
+		for _, step := range pathSteps[:4] {
+			visit(GridLocation{tile.X + step.X, tile.Z + step.Z})
+		}
+	}
+	return reachable
+}
+
+// baseReachable reports whether any tile around the base is reachable.
+func (m *Map) baseReachable(base MapBase, size int, reachable map[GridLocation]bool) bool {
+	for _, tile := range ring(base, size) {
+		if reachable[tile] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package sim_test
+
+import (
+	"reflect"
+	"testing"
+
+	"hackcu2025/sim"
+)
+
+func generated(seed int64, players int) sim.MapChoice {
+	choice := sim.MapChoice{Options: sim.DefaultMapOptions()}
+	choice.Options.Seed = seed
+	choice.Options.Players = players
+	return choice
+}
+
+func TestStartingMapFollowsSeed(t *testing.T) {
+	catalog, err := sim.LoadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := sim.StartingMap(generated(7, 4), "", catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sim.StartingMap(generated(7, 4), "", catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("seed 7 generated two different maps")
+	}
+	if len(a.Bases) != 4 {
+		t.Errorf("asked for 4 players, got %v bases", len(a.Bases))
+	}
+	c, err := sim.StartingMap(generated(8, 4), "", catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(a.Resources, c.Resources) {
+		t.Errorf("seeds 7 and 8 generated the same resources")
+	}
+}
+
+func TestStartingMapTeams(t *testing.T) {
+	catalog, err := sim.LoadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	choice := generated(1, 4)
+	choice.Teams = []int{1, 2, 1, 2}
+	m, err := sim.StartingMap(choice, "", catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, base := range m.Bases {
+		if base.Team != choice.Teams[i] {
+			t.Errorf("base %v is on team %v, want %v", i, base.Team, choice.Teams[i])
+		}
+	}
+
+	for _, teams := range [][]int{{1, 2}, {1, 2, 1, -1}, {1, 2, 1, 9}} {
+		choice.Teams = teams
+		if _, err := sim.StartingMap(choice, "", catalog); err == nil {
+			t.Errorf("teams %v for 4 players were accepted", teams)
+		}
+	}
+}
+
+func TestStartingMapByName(t *testing.T) {
+	catalog, err := sim.LoadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := sim.MapNames("")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no built-in maps: %v", err)
+	}
+	// The options are for generated maps only.
+	choice := generated(1, 8)
+	choice.Name = names[0]
+	m, err := sim.StartingMap(choice, "", catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := sim.LoadMap("", names[0], catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("StartingMap(%q) isn't the map file", names[0])
+	}
+
+	choice.Name = "../catalog"
+	if _, err := sim.StartingMap(choice, "", catalog); err == nil {
+		t.Errorf("map %q was loaded", choice.Name)
+	}
+}
+
+func TestVictoryConditionsCheck(t *testing.T) {
+	for _, c := range []struct {
+		conditions sim.VictoryConditions
+		ok         bool
+	}{
+		{sim.DefaultVictoryConditions(), true},
+		{sim.VictoryConditions{Units: true}, true},
+		{sim.VictoryConditions{TimeLimit: 600}, true},
+		{sim.VictoryConditions{}, false},
+		{sim.VictoryConditions{TownHalls: true, TimeLimit: -1}, false},
+	} {
+		if err := c.conditions.Check(); (err == nil) != c.ok {
+			t.Errorf("%+v: Check() = %v", c.conditions, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package sim
+
+// Builder order kinds.
+const (
+	orderIdle   = "idle"
+	orderMove   = "move"
+	orderGather = "gather"
+	orderBuild  = "build"
+	orderRepair = "repair"
+)
+
+// A BuilderOrder is what a builder is currently doing. TargetID is the
+// resource node or building the order is about, or -1. A gather order with
+// no target works the nearest node of ResourceType, or of any type if
+// ResourceType is empty, and then keeps it as its target.
+type BuilderOrder struct {
+	Kind         string   `json:"kind"`
+	TargetID     EntityID `json:"targetId"`
+	ResourceType string   `json:"resourceType,omitempty"`
+}
+
+var idleOrder = BuilderOrder{Kind: orderIdle, TargetID: -1}
+
+// Repairs go at this fraction of the speed the building went up at.
+const repairRate = 0.5
+
+func gatherOrder(resourceType string) BuilderOrder {
+	return BuilderOrder{Kind: orderGather, TargetID: -1, ResourceType: resourceType}
+}
+
+// setOrder gives the builder a new order. A gatherer pulled away to build
+// or repair goes back to gathering once that job is done.
+func (b *Builder) setOrder(order BuilderOrder) {
+	b.resume = idleOrder
+	if (order.Kind == orderBuild || order.Kind == orderRepair) && b.Order.Kind == orderGather {
+		b.resume = b.Order
+	}
+	b.Order = order
+}
+
+// finishOrder ends the builder's current order, resuming gathering if that
+// is what it was doing before, and otherwise leaving it idle where it is.
+func (b *Builder) finishOrder() {
+	b.Order = b.resume
+	b.resume = idleOrder
+	if b.Order.Kind == orderIdle {
+		b.GoalPosition = b.Position
+	}
+}
+
+// isFree reports whether the builder may be put to work on a new foundation
+// without overriding something the player told it to do.
+func (b *Builder) isFree() bool {
+	return b.Order.Kind == orderIdle || b.Order.Kind == orderGather
+}
+
+func (b *Builder) carrying() float64 {
+	return b.Gold + b.Stone + b.Wood
+}
+
+// gatherTarget returns the resource node a gathering builder should work:
+// its ordered node while that lasts, then the nearest one of the same type,
+// which becomes the ordered node so the search isn't made every tick.
+func (g *Game) gatherTarget(builder *Builder, player *Player) *Resource {
+	if resource, ok := g.resources[builder.Order.TargetID]; ok && g.canGather(resource, player) {
+		return resource
+	}
+	resourceType := builder.Order.ResourceType
+	entry, found := g.resourceIndex.nearest(builder.Position, func(e spatialEntry) bool {
+		resource := g.resources[e.id]
+		return g.canGather(resource, player) && (resourceType == "" || resource.ResourceType == resourceType)
+	})
+	if !found {
+		return nil
+	}
+	builder.Order.TargetID = entry.id
+	return g.resources[entry.id]
+}
+
+// gather has a builder mine its target until it's full and then carry the
+// load to the nearest drop-off. With nothing left to mine it brings back
+// what it has and goes idle.
+func (g *Game) gather(builder *Builder, player *Player, dt float64) {
+	resource := g.gatherTarget(builder, player)
+	builder.ResourceTarget = resource
+	if builder.carrying() >= builder.def.CarryingCapacity || (resource == nil && builder.carrying() > 0) {
+		g.deposit(builder, player)
+		return
+	}
+	if resource == nil {
+		builder.finishOrder()
+		return
+	}
+	// A node under a mine is worked from the mine's edge.
+	builder.GoalPosition = resource.GetPosition()
+	distance := resource.distanceTo(builder.Position)
+	if mine, ok := player.buildings[resource.MineID]; ok {
+		builder.GoalPosition = mine.GetPosition()
+		distance = mine.distanceTo(builder.Position)
+	}
+
+	// See if resource is in reach
+	if distance >= builder.def.Reach {
+		return
+	}
+	g.mineResource(builder, resource, g.gatherRate(builder, resource, player)*dt)
+}
+
+// deposit walks a builder to the nearest drop-off and unloads it there. A
+// builder with nowhere to take its load holds on to it.
+func (g *Game) deposit(builder *Builder, player *Player) {
+	dropOff := g.nearestDropOff(player, builder.Position)
+	if dropOff == nil {
+		return
+	}
+	builder.GoalPosition = dropOff.GetPosition()
+	if dropOff.distanceTo(builder.Position) >= builder.def.Reach {
+		return
+	}
+	player.stats.ResourcesGathered += builder.carrying()
+	player.gold += builder.Gold
+	player.stone += builder.Stone
+	player.wood += builder.Wood
+	builder.Gold = 0
+	builder.Stone = 0
+	builder.Wood = 0
+}
+
+// repair has a builder walk to a damaged building and patch it up. It
+// reports false once the building is at full health or gone.
+func (g *Game) repair(builder *Builder, player *Player, dt float64) bool {
+	building, ok := player.buildings[builder.Order.TargetID]
+	if !ok || !building.IsComplete() || building.Health >= building.MaxHealth {
+		return false
+	}
+	builder.GoalPosition = building.GetPosition()
+	if building.distanceTo(builder.Position) >= builder.def.Reach {
+		return true
+	}
+	building.SetHealth(building.Health + repairRate*dt/building.def.BuildTime*building.MaxHealth)
+	return true
+}
+
+// updateBuilder carries out the builder's current order.
+func (g *Game) updateBuilder(builder *Builder, player *Player, dt float64) {
+	switch builder.Order.Kind {
+	case orderMove:
+		if len(builder.path.waypoints) == 0 {
+			builder.finishOrder()
+		}
+	case orderGather:
+		g.gather(builder, player, dt)
+	case orderBuild:
+		if !g.construct(builder, player, dt) {
+			builder.finishOrder()
+		}
+	case orderRepair:
+		if !g.repair(builder, player, dt) {
+			builder.finishOrder()
+		}
+	}
+}
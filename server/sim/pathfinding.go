@@ -0,0 +1,361 @@
+package sim
+
+import (
+	"container/heap"
+	"math"
+)
+
+// Buildings and resource nodes occupy a square of tiles. A footprint of
+// size n anchored at pos covers tiles pos-1 through pos+n-2 on both axes,
+// which matches where the client draws the models. Building sizes come
+// from the catalog.
+const resourceSize = 1
+
+// maxPathSearch caps how many tiles one A* search may expand. Goals that
+// can't be reached within it get a path to the closest tile found instead.
+const maxPathSearch = 20000
+
+// pathMargin is how far past the playable area units may path.
+const pathMargin = 10
+
+// Tile distance for straight and diagonal steps.
+const straightCost = 1.0
+const diagonalCost = math.Sqrt2
+
+func footprint(pos GridLocation, size int) (GridLocation, GridLocation) {
+	low := GridLocation{pos.X - 1, pos.Z - 1}
+	high := GridLocation{pos.X + size - 2, pos.Z + size - 2}
+	return low, high
+}
+
+func (b *Building) size() int {
+	return b.def.Size
+}
+
+// distanceToFootprint is the distance from p to the nearest point of the
+// footprint's tiles, or 0 if p is inside it.
+func distanceToFootprint(p Float3, pos GridLocation, size int) float64 {
+	low, high := footprint(pos, size)
+	dx := max(float64(low.X)-p.X, 0, p.X-float64(high.X+1))
+	dz := max(float64(low.Z)-p.Z, 0, p.Z-float64(high.Z+1))
+	return math.Sqrt(dx*dx + dz*dz)
+}
+
+func (b *Building) distanceTo(p Float3) float64 {
+	return distanceToFootprint(p, b.Position, b.size())
+}
+
+func (r *Resource) distanceTo(p Float3) float64 {
+	return distanceToFootprint(p, r.Position, resourceSize)
+}
+
+// setBlocked adds delta to the blocked count of every tile under a
+// footprint. Counts rather than flags keep overlapping footprints correct
+// when one of them goes away.
+func (g *Game) setBlocked(pos GridLocation, size int, delta int) {
+	low, high := footprint(pos, size)
+	for x := low.X; x <= high.X; x++ {
+		for z := low.Z; z <= high.Z; z++ {
+			tile := GridLocation{x, z}
+			g.blocked[tile] += delta
+			if g.blocked[tile] <= 0 {
+				delete(g.blocked, tile)
+			}
+		}
+	}
+	g.blockedVersion++
+}
+
+func (g *Game) isBlocked(tile GridLocation) bool {
+	return g.blocked[tile] > 0
+}
+
+// isAreaBlocked reports whether any tile of a footprint is already taken by
+// a building or resource node, going by blocked.
+func isAreaBlocked(blocked func(GridLocation) bool, pos GridLocation, size int) bool {
+	low, high := footprint(pos, size)
+	for x := low.X; x <= high.X; x++ {
+		for z := low.Z; z <= high.Z; z++ {
+			if blocked(GridLocation{x, z}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *Game) inPathBounds(tile GridLocation) bool {
+	m := g.gameMap
+	return tile.X >= m.Min.X-pathMargin && tile.X < m.Max.X+pathMargin &&
+		tile.Z >= m.Min.Z-pathMargin && tile.Z < m.Max.Z+pathMargin
+}
+
+func tileCenter(tile GridLocation, y float64) Float3 {
+	return Float3{float64(tile.X) + 0.5, y, float64(tile.Z) + 0.5}
+}
+
+// clampToTile moves p to the nearest point inside tile. The far edges
+// belong to the next tile over, so they're pulled in by the smallest step.
+func clampToTile(p Float3, tile GridLocation) Float3 {
+	maxX := math.Nextafter(float64(tile.X+1), math.Inf(-1))
+	maxZ := math.Nextafter(float64(tile.Z+1), math.Inf(-1))
+	return Float3{
+		X: min(max(p.X, float64(tile.X)), maxX),
+		Y: p.Y,
+		Z: min(max(p.Z, float64(tile.Z)), maxZ),
+	}
+}
+
+// A navPath is the route a Movable is following to its goal position.
+type navPath struct {
+	goal      Float3
+	planned   bool
+	waypoints []Float3
+	// The game's blockedVersion when the path was planned.
+	version int
+}
+
+// nearestOpenTile finds the free tile closest to from among those
+// surrounding the blocked area around goal.
+func (g *Game) nearestOpenTile(goal GridLocation, from Float3) GridLocation {
+	seen := map[GridLocation]bool{goal: true}
+	frontier := []GridLocation{goal}
+	for len(frontier) > 0 {
+		var next []GridLocation
+		var open []GridLocation
+		for _, tile := range frontier {
+			for _, step := range pathSteps {
+				n := GridLocation{tile.X + step.X, tile.Z + step.Z}
+				if seen[n] || !g.inPathBounds(n) {
+					continue
+				}
+				seen[n] = true
+				if g.isBlocked(n) {
+					next = append(next, n)
+				} else {
+					open = append(open, n)
+				}
+			}
+		}
+		if len(open) > 0 {
+			best := open[0]
+			for _, tile := range open[1:] {
+				if tileCenter(tile, 0).subtract(from).length() < tileCenter(best, 0).subtract(from).length() {
+					best = tile
+				}
+			}
+			return best
+		}
+		frontier = next
+	}
+	return goal
+}
+
+var pathSteps = []GridLocation{
+	{1, 0}, {-1, 0}, {0, 1}, {0, -1},
+	{1, 1}, {1, -1}, {-1, 1}, {-1, -1},
+}
+
+type pathNode struct {
+	tile  GridLocation
+	cost  float64
+	score float64
+	index int
+}
+
+type pathQueue []*pathNode
+
+func (q pathQueue) Len() int { return len(q) }
+func (q pathQueue) Less(i, j int) bool {
+	if q[i].score != q[j].score {
+		return q[i].score < q[j].score
+	}
+	return q[i].cost > q[j].cost
+}
+func (q pathQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *pathQueue) Push(x any) {
+	node := x.(*pathNode)
+	node.index = len(*q)
+	*q = append(*q, node)
+}
+func (q *pathQueue) Pop() any {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+func octileDistance(a, b GridLocation) float64 {
+	dx := math.Abs(float64(a.X - b.X))
+	dz := math.Abs(float64(a.Z - b.Z))
+	return straightCost*math.Abs(dx-dz) + diagonalCost*min(dx, dz)
+}
+
+// findPath runs A* over the tile grid from start to goal and returns the
+// tiles along the way, excluding start. Units standing inside a blocked area
+// (say, freshly spawned on top of a building) may walk out of it, but never
+// into one. If goal can't be reached the path leads to the closest tile the
+// search got to.
+func (g *Game) findPath(start, goal GridLocation) []GridLocation {
+	cameFrom := make(map[GridLocation]GridLocation)
+	costs := map[GridLocation]float64{start: 0}
+	nodes := make(map[GridLocation]*pathNode)
+	closed := make(map[GridLocation]bool)
+
+	open := &pathQueue{}
+	startNode := &pathNode{tile: start, score: octileDistance(start, goal)}
+	heap.Push(open, startNode)
+	nodes[start] = startNode
+
+	closest := start
+	closestDistance := octileDistance(start, goal)
+	for expanded := 0; open.Len() > 0 && expanded < maxPathSearch; expanded++ {
+		current := heap.Pop(open).(*pathNode)
+		delete(nodes, current.tile)
+		closed[current.tile] = true
+		if current.tile == goal {
+			closest = goal
+			break
+		}
+		if d := octileDistance(current.tile, goal); d < closestDistance {
+			closest, closestDistance = current.tile, d
+		}
+
+		leavingBlocked := g.isBlocked(current.tile)
+		for _, step := range pathSteps {
+			n := GridLocation{current.tile.X + step.X, current.tile.Z + step.Z}
+			if closed[n] || !g.inPathBounds(n) {
+				continue
+			}
+			if !leavingBlocked && g.isBlocked(n) {
+				continue
+			}
+			stepCost := straightCost
+			if step.X != 0 && step.Z != 0 {
+				// Don't cut corners past a blocked tile.
+				if !leavingBlocked && (g.isBlocked(GridLocation{current.tile.X + step.X, current.tile.Z}) ||
+					g.isBlocked(GridLocation{current.tile.X, current.tile.Z + step.Z})) {
+					continue
+				}
+				stepCost = diagonalCost
+			}
+			cost := current.cost + stepCost
+			if known, ok := costs[n]; ok && known <= cost {
+				continue
+			}
+			costs[n] = cost
+			cameFrom[n] = current.tile
+			score := cost + octileDistance(n, goal)
+			if node, ok := nodes[n]; ok {
+				node.cost = cost
+				node.score = score
+				heap.Fix(open, node.index)
+			} else {
+				node := &pathNode{tile: n, cost: cost, score: score}
+				heap.Push(open, node)
+				nodes[n] = node
+			}
+		}
+	}
+
+	var tiles []GridLocation
+	for tile := closest; tile != start; tile = cameFrom[tile] {
+		tiles = append(tiles, tile)
+	}
+	for i, j := 0, len(tiles)-1; i < j; i, j = i+1, j-1 {
+		tiles[i], tiles[j] = tiles[j], tiles[i]
+	}
+	return tiles
+}
+
+// hasLineOfSight reports whether a unit can walk straight from a to b
+// without touching a blocked tile.
+func (g *Game) hasLineOfSight(a, b Float3) bool {
+	delta := b.subtract(a)
+	steps := int(math.Ceil(math.Hypot(delta.X, delta.Z) / 0.25))
+	for i := 1; i <= steps; i++ {
+		p := a.add(delta.scale(float64(i) / float64(steps)))
+		if g.isBlocked(float3ToGridLocation(p)) {
+			return false
+		}
+	}
+	return true
+}
+
+// planPath works out the waypoints from position to goal. A goal inside a
+// building or resource node is replaced by the closest point next to it.
+func (g *Game) planPath(path *navPath, position Float3, goal Float3) {
+	path.goal = goal
+	path.planned = true
+	path.version = g.blockedVersion
+	path.waypoints = path.waypoints[:0]
+
+	start := float3ToGridLocation(position)
+	goalTile := float3ToGridLocation(goal)
+	end := goal
+	if g.isBlocked(goalTile) {
+		goalTile = g.nearestOpenTile(goalTile, position)
+		end = clampToTile(goal, goalTile)
+	}
+	if start == goalTile || g.hasLineOfSight(position, end) {
+		path.waypoints = append(path.waypoints, end)
+		return
+	}
+
+	tiles := g.findPath(start, goalTile)
+	if len(tiles) == 0 || tiles[len(tiles)-1] != goalTile {
+		// Unreachable: head for the closest tile we found.
+		if len(tiles) > 0 {
+			end = tileCenter(tiles[len(tiles)-1], goal.Y)
+		} else {
+			end = position
+		}
+	} else {
+		tiles = tiles[:len(tiles)-1]
+	}
+
+	// Drop every waypoint we can see past, so units walk straight lines
+	// instead of following the tile grid.
+	from := position
+	for i, tile := range tiles {
+		next := end
+		if i+1 < len(tiles) {
+			next = tileCenter(tiles[i+1], goal.Y)
+		}
+		if g.hasLineOfSight(from, next) {
+			continue
+		}
+		waypoint := tileCenter(tile, goal.Y)
+		path.waypoints = append(path.waypoints, waypoint)
+		from = waypoint
+	}
+	path.waypoints = append(path.waypoints, end)
+}
+
+// updatePath replans m's route when its goal has moved to a different tile,
+// or when a building or resource node has come or gone since the route was
+// planned and the unit still has somewhere to go. Small moves of the goal
+// within the same tile just shift the last waypoint, so chasing a target
+// doesn't run a search every tick.
+func (g *Game) updatePath(m Movable) {
+	path := m.getPath()
+	goal := m.GetGoalPosition()
+	if path.planned && path.version != g.blockedVersion && len(path.waypoints) > 0 {
+		g.planPath(path, m.GetPosition(), goal)
+		return
+	}
+	if path.planned && path.goal == goal {
+		return
+	}
+	if path.planned && len(path.waypoints) > 0 && float3ToGridLocation(path.goal) == float3ToGridLocation(goal) &&
+		!g.isBlocked(float3ToGridLocation(goal)) {
+		path.goal = goal
+		path.waypoints[len(path.waypoints)-1] = goal
+		return
+	}
+	g.planPath(path, m.GetPosition(), goal)
+}
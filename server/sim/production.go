@@ -0,0 +1,87 @@
+package sim
+
+import "slices"
+
+// productionQueueLimit caps how many units one building can have queued,
+// including the one in production.
+const productionQueueLimit = 5
+
+// A ProductionItem is one unit waiting in a building's queue. Only the
+// item at the front of the queue makes progress.
+type ProductionItem struct {
+	UnitType  string  `json:"unitType"`
+	Remaining float64 `json:"remaining"`
+	BuildTime float64 `json:"buildTime"`
+	Cost      Cost    `json:"cost"`
+}
+
+// canProduce reports whether the building trains units of unitType.
+func (b *Building) canProduce(unitType string) bool {
+	return slices.Contains(b.def.Produces, unitType) && b.IsComplete()
+}
+
+// enqueueUnit charges the player for a unit and adds it to the back of the
+// building's queue.
+func (g *Game) enqueueUnit(building *Building, unitType string, playerId PlayerID) {
+	def := g.catalog.Units[unitType]
+	cost := def.Cost
+	g.players[playerId].payCost(&cost)
+	building.Queue = append(building.Queue, ProductionItem{
+		UnitType:  unitType,
+		Remaining: def.BuildTime,
+		BuildTime: def.BuildTime,
+		Cost:      cost,
+	})
+}
+
+// cancelUnit drops a queued unit and refunds what was paid for it.
+func (g *Game) cancelUnit(building *Building, index int, playerId PlayerID) {
+	item := building.Queue[index]
+	player := g.players[playerId]
+	player.gold += item.Cost.Gold
+	player.stone += item.Cost.Stone
+	player.wood += item.Cost.Wood
+	building.Queue = append(building.Queue[:index], building.Queue[index+1:]...)
+}
+
+// updateProduction advances the unit at the front of the queue and spawns
+// it when it's done. Cooldown and MaxCooldown mirror the current item so
+// clients can draw a progress bar.
+func (g *Game) updateProduction(building *Building, playerId PlayerID, dt float64) {
+	if !building.IsComplete() || len(building.Queue) == 0 {
+		building.Cooldown = 0
+		return
+	}
+
+	item := &building.Queue[0]
+	item.Remaining -= dt
+	building.Cooldown = max(item.Remaining, 0)
+	building.MaxCooldown = item.BuildTime
+	if item.Remaining > 0 {
+		return
+	}
+
+	unitType := item.UnitType
+	building.Queue = building.Queue[1:]
+	g.spawnUnit(building, unitType, playerId)
+}
+
+// spawnUnit creates a unit just outside a building, on the side facing its
+// rally point, and sends it there.
+func (g *Game) spawnUnit(building *Building, unitType string, playerId PlayerID) {
+	rally := building.GetPosition()
+	if building.RallyPoint != nil {
+		rally = *building.RallyPoint
+	}
+	exit := g.nearestOpenTile(building.Position, rally)
+	position := tileCenter(exit, 0)
+
+	unit := g.createUnit(unitType, position, playerId)
+	g.players[playerId].stats.UnitsTrained++
+	if building.RallyPoint != nil {
+		unit.SetGoalPosition(*building.RallyPoint)
+		if builder, ok := unit.(*Builder); ok {
+			builder.setOrder(BuilderOrder{Kind: orderMove, TargetID: -1})
+		}
+	}
+}
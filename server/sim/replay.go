@@ -0,0 +1,162 @@
+package sim
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+const replayVersion = 1
+
+// A Replay is everything needed to play a match back: the seed, catalog,
+// map and victory conditions it started from, and every command the
+// simulation handled, stamped with its tick. Since the simulation is
+// deterministic, feeding those commands back in at the same ticks plays out
+// the same match. Replays are saved as gzipped JSON.
+type Replay struct {
+	Version  int               `json:"version"`
+	Seed     int64             `json:"seed"`
+	Catalog  json.RawMessage   `json:"catalog"`
+	Map      *Map              `json:"map"`
+	Victory  VictoryConditions `json:"victory"`
+	Ticks    int               `json:"ticks"`
+	Commands []PlayerCommand   `json:"commands"`
+	Result   *GameOver         `json:"result"`
+}
+
+// Replay records the match so far.
+func (s *Simulation) Replay() (*Replay, error) {
+	catalog, err := json.Marshal(s.game.catalog)
+	if err != nil {
+		return nil, err
+	}
+	return &Replay{
+		Version:  replayVersion,
+		Seed:     s.game.seed,
+		Catalog:  catalog,
+		Map:      s.game.gameMap,
+		Victory:  s.game.victory,
+		Ticks:    s.game.tick,
+		Commands: slices.Clone(s.log),
+		Result:   s.game.over,
+	}, nil
+}
+
+// SaveReplay writes a replay to path as gzipped JSON.
+func SaveReplay(path string, replay *Replay) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := gzip.NewWriter(file)
+	if err := json.NewEncoder(writer).Encode(replay); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// LoadReplay reads a replay saved by SaveReplay.
+func LoadReplay(path string) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	var replay Replay
+	if err := json.NewDecoder(reader).Decode(&replay); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	if replay.Version != replayVersion {
+		return nil, fmt.Errorf("%v: replay version %v, want %v", path, replay.Version, replayVersion)
+	}
+	return &replay, nil
+}
+
+// NewSimulation sets up the game the replay starts from.
+func (r *Replay) NewSimulation() (*Simulation, error) {
+	catalog, err := parseCatalog(r.Catalog)
+	if err != nil {
+		return nil, err
+	}
+	game := NewGame(r.Seed, catalog, r.Map, r.Victory)
+	return NewSimulation(game), nil
+}
+
+// ReplayStep feeds in the recorded commands from next on that are due by
+// the current tick, simulates the tick and returns the index of the first
+// command it didn't get to. Recorded commands that no longer decode, say
+// because the replay was made with a different version of the game, are
+// skipped and reported in the error.
+func (s *Simulation) ReplayStep(commands []PlayerCommand, next int) (int, error) {
+	var skipped []error
+	for next < len(commands) && commands[next].Tick <= s.Tick() {
+		c := commands[next]
+		if err := s.Queue(c.Player, c.Key, c.Args); err != nil {
+			skipped = append(skipped, fmt.Errorf("replay command %v at tick %v: %w", c.Key, c.Tick, err))
+		}
+		next++
+	}
+	s.Step()
+	return next, errors.Join(skipped...)
+}
+
+// A Delayed is a second copy of a simulation kept a fixed number of ticks
+// behind it, by replaying the live simulation's commands as they come due.
+// Spectators of a delayed match watch the copy, so they can't call out to
+// a player what the other side is doing.
+type Delayed struct {
+	live  *Simulation
+	sim   *Simulation
+	delay int
+	// Index in the live command log of the next command to feed in.
+	next int
+}
+
+// NewDelayed starts a copy of live that stays delay ticks behind it.
+func NewDelayed(live *Simulation, delay int) (*Delayed, error) {
+	replay, err := live.Replay()
+	if err != nil {
+		return nil, err
+	}
+	simulation, err := replay.NewSimulation()
+	if err != nil {
+		return nil, err
+	}
+	return &Delayed{live: live, sim: simulation, delay: delay}, nil
+}
+
+// CatchUp advances the copy to its delay behind live. The caller must hold
+// whatever guards live. Recorded commands that no longer decode are skipped
+// and reported in the error.
+func (d *Delayed) CatchUp() error {
+	var skipped []error
+	for d.sim.Tick() < d.live.Tick()-d.delay {
+		var err error
+		d.next, err = d.sim.ReplayStep(d.live.Log(), d.next)
+		if err != nil {
+			skipped = append(skipped, err)
+		}
+	}
+	return errors.Join(skipped...)
+}
+
+// Game is the delayed copy's game.
+func (d *Delayed) Game() *Game {
+	return d.sim.game
+}
+
+// Delay is how many ticks behind live the copy is kept.
+func (d *Delayed) Delay() int {
+	return d.delay
+}
@@ -0,0 +1,178 @@
+package sim
+
+// Resource event types.
+const (
+	eventResourceDepleted = "resourceDepleted"
+	eventResourceRegrown  = "resourceRegrown"
+)
+
+// A ResourceEvent reports a resource node running out or growing back
+// during the tick.
+type ResourceEvent struct {
+	Type         string       `json:"type"`
+	ResourceID   EntityID     `json:"resourceId"`
+	ResourceType string       `json:"resourceType"`
+	Position     GridLocation `json:"position"`
+}
+
+func (g *Game) createResource(resourceType string, position GridLocation) *Resource {
+	def := g.catalog.Resources[resourceType]
+	entityId := g.newEntityID()
+	resource := &Resource{
+		Id:           entityId,
+		ResourceType: resourceType,
+		Position:     position,
+		Amount:       def.Amount,
+		MineID:       -1,
+		def:          def,
+	}
+	g.resources[entityId] = resource
+	g.resourceIndex.insert(entityId, 0, resource.GetPosition(), 0)
+	g.setBlocked(position, resourceSize, 1)
+	return resource
+}
+
+// available reports whether the node can be mined right now.
+func (r *Resource) available() bool {
+	return !r.Depleted && r.Amount > 0
+}
+
+// mineOf returns the player's finished building boosting this node, or nil
+// if there is none.
+func (g *Game) mineOf(resource *Resource, player *Player) *Building {
+	mine, ok := player.buildings[resource.MineID]
+	if !ok || !mine.IsComplete() {
+		return nil
+	}
+	return mine
+}
+
+// canGather reports whether a player's builders may mine the node. A node
+// under a mine can only be worked by the mine's owner.
+func (g *Game) canGather(resource *Resource, player *Player) bool {
+	if !resource.available() {
+		return false
+	}
+	if resource.MineID < 0 {
+		return true
+	}
+	_, ok := player.buildings[resource.MineID]
+	return ok
+}
+
+// gatherRate is how much a builder of the player gets out of the node per
+// second of mining.
+func (g *Game) gatherRate(builder *Builder, resource *Resource, player *Player) float64 {
+	rate := builder.def.MineSpeed * resource.def.GatherRate
+	if mine := g.mineOf(resource, player); mine != nil {
+		rate *= 1 + mine.def.YieldBonus
+	}
+	return rate
+}
+
+// cargo is the builder's load of one resource type.
+func (b *Builder) cargo(resourceType string) *float64 {
+	switch resourceType {
+	case "gold":
+		return &b.Gold
+	case "stone":
+		return &b.Stone
+	default:
+		return &b.Wood
+	}
+}
+
+// mineResource moves up to amount out of the node and into the builder's
+// load, depleting the node if that empties it.
+func (g *Game) mineResource(builder *Builder, resource *Resource, amount float64) {
+	mined := min(resource.Amount, amount)
+	*builder.cargo(resource.ResourceType) += mined
+	resource.Amount -= mined
+	if resource.Amount <= 1 {
+		g.depleteResource(resource)
+	}
+}
+
+// depleteResource empties a node. Nodes that regrow stay where they are;
+// the rest are removed.
+func (g *Game) depleteResource(resource *Resource) {
+	resource.Amount = 0
+	g.events = append(g.events, resource.event(eventResourceDepleted))
+	if resource.def.RegrowthRate > 0 {
+		resource.Depleted = true
+		return
+	}
+	g.deleteEntity(resource.Id)
+}
+
+// regrowResources grows regrowing nodes back toward their full amount. A
+// depleted node becomes minable again once it is full.
+func (g *Game) regrowResources(dt float64) {
+	for _, rid := range sortedKeys(g.resources) {
+		resource := g.resources[rid]
+		full := resource.def.Amount
+		if resource.def.RegrowthRate == 0 || resource.Amount >= full {
+			continue
+		}
+		resource.Amount = min(resource.Amount+resource.def.RegrowthRate*dt, full)
+		if resource.Depleted && resource.Amount >= full {
+			resource.Depleted = false
+			g.events = append(g.events, resource.event(eventResourceRegrown))
+		}
+	}
+}
+
+// tile is the one tile the node covers.
+func (r *Resource) tile() GridLocation {
+	tile, _ := footprint(r.Position, resourceSize)
+	return tile
+}
+
+func (r *Resource) event(eventType string) ResourceEvent {
+	return ResourceEvent{
+		Type:         eventType,
+		ResourceID:   r.Id,
+		ResourceType: r.ResourceType,
+		Position:     r.Position,
+	}
+}
+
+// nodeUnder returns the free node of resourceType among resources under a
+// footprint, or nil if there isn't exactly one.
+func nodeUnder(resources map[EntityID]*Resource, pos GridLocation, size int, resourceType string) *Resource {
+	low, high := footprint(pos, size)
+	var found *Resource
+	for _, rid := range sortedKeys(resources) {
+		resource := resources[rid]
+		p := resource.tile()
+		if p.X < low.X || p.X > high.X || p.Z < low.Z || p.Z > high.Z {
+			continue
+		}
+		if found != nil || resource.ResourceType != resourceType || resource.MineID >= 0 {
+			return nil
+		}
+		found = resource
+	}
+	return found
+}
+
+// canPlaceOnNode reports whether a building that goes over a node of
+// resourceType fits at pos, going by the nodes in resources and the tiles
+// taken by blocked: the footprint must hold one such node and be free
+// everywhere else.
+func canPlaceOnNode(resources map[EntityID]*Resource, blocked func(GridLocation) bool, pos GridLocation, size int, resourceType string) bool {
+	node := nodeUnder(resources, pos, size, resourceType)
+	if node == nil {
+		return false
+	}
+	low, high := footprint(pos, size)
+	for x := low.X; x <= high.X; x++ {
+		for z := low.Z; z <= high.Z; z++ {
+			tile := GridLocation{x, z}
+			if tile != node.tile() && blocked(tile) {
+				return false
+			}
+		}
+	}
+	return true
+}
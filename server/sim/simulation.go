@@ -0,0 +1,111 @@
+// Package sim is the game itself: the units, buildings and resources, the
+// rules for commanding them, and the fixed-step simulation that plays a
+// match out. It knows nothing about networking, so servers, replays and
+// offline tools can all run it the same way. Given the same seed, map and
+// commands it plays out the same match every time.
+package sim
+
+import "encoding/json"
+
+// The simulation always advances in steps of tickDt seconds of game time,
+// no matter how often or how late whoever is running it gets to.
+const TickRate = 20
+const tickDt float64 = 1.0 / TickRate
+
+// A PlayerCommand is one client command, stamped with the tick it was
+// applied on.
+type PlayerCommand struct {
+	Tick    int             `json:"tick"`
+	Player  PlayerID        `json:"player"`
+	Key     string          `json:"key"`
+	Args    json.RawMessage `json:"args"`
+	command Command
+}
+
+// A Rejection is a command that failed validation when its tick came up.
+// Index is its place among the commands queued for that tick, counting
+// from 0.
+type Rejection struct {
+	Player PlayerID
+	Err    error
+	Index  int
+}
+
+// A Simulation owns a Game and feeds it commands at tick boundaries. Given
+// the same seed and the same command stream it produces the same GameState
+// on every run.
+type Simulation struct {
+	game    *Game
+	pending []PlayerCommand
+
+	// Every command Step has handled, accepted or not, in the order it
+	// handled them. Together with the game's starting point this is enough
+	// to replay the match.
+	log []PlayerCommand
+}
+
+// NewSimulation runs game on from wherever it stands.
+func NewSimulation(game *Game) *Simulation {
+	return &Simulation{game: game}
+}
+
+// Queue decodes a command and buffers it until the start of the next tick.
+// Commands that cannot be decoded are refused straight away.
+func (s *Simulation) Queue(player PlayerID, key string, args json.RawMessage) error {
+	command, err := parseCommand(key, args)
+	if err != nil {
+		return err
+	}
+	s.pending = append(s.pending, PlayerCommand{
+		Player:  player,
+		Key:     key,
+		Args:    args,
+		command: command,
+	})
+	return nil
+}
+
+// Step validates and applies every queued command in arrival order and then
+// advances the game by one fixed tick. Commands that fail validation are
+// returned so they can be reported to their senders.
+func (s *Simulation) Step() []Rejection {
+	if s.game.over != nil {
+		s.pending = s.pending[:0]
+		return nil
+	}
+	var rejected []Rejection
+	for i, c := range s.pending {
+		c.Tick = s.game.tick
+		s.log = append(s.log, c)
+		if err := s.game.validateFrom(c.Player, c.Key, c.command); err != nil {
+			rejected = append(rejected, Rejection{Player: c.Player, Err: err, Index: i})
+			continue
+		}
+		c.command.apply(s.game, c.Player)
+	}
+	s.pending = s.pending[:0]
+	s.game.update(tickDt)
+	return rejected
+}
+
+// Result is how the match ended, or nil while it is still going. Step does
+// nothing once the match is over.
+func (s *Simulation) Result() *GameOver {
+	return s.game.over
+}
+
+// Tick is the number of ticks simulated so far.
+func (s *Simulation) Tick() int {
+	return s.game.tick
+}
+
+// Game is the game being simulated. Callers may read its state between
+// steps but should only change it through Queue.
+func (s *Simulation) Game() *Game {
+	return s.game
+}
+
+// Log is every command Step has handled so far. It must not be modified.
+func (s *Simulation) Log() []PlayerCommand {
+	return s.log
+}
@@ -0,0 +1,123 @@
+package sim_test
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"hackcu2025/sim"
+)
+
+// newMatch starts a four player match on a map generated from seed, small
+// enough that the knights meet before the time runs out.
+func newMatch(t *testing.T, seed int64) *sim.Simulation {
+	t.Helper()
+	catalog, err := sim.LoadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	choice := generated(seed, 4)
+	choice.Options.Size = 50
+	m, err := sim.StartingMap(choice, "", catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	victory := sim.VictoryConditions{TownHalls: true, TimeLimit: 120}
+	return sim.NewSimulation(sim.NewGame(seed, catalog, m, victory))
+}
+
+// stateHash fingerprints everything about the game that clients can see.
+func stateHash(t *testing.T, s *sim.Simulation) [sha256.Size]byte {
+	t.Helper()
+	data, err := json.Marshal(s.Game().GetState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha256.Sum256(data)
+}
+
+func queue(t *testing.T, s *sim.Simulation, pid sim.PlayerID, key string, args any) {
+	t.Helper()
+	data, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Queue(pid, key, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// play gives every player something to do: gather, train builders and
+// knights, put up a barracks and send the knights at the next player's
+// town hall, so that the economy, production, pathing and combat all get
+// exercised.
+func play(t *testing.T, s *sim.Simulation) {
+	t.Helper()
+	tick := s.Tick()
+	state := s.Game().GetState()
+	for pid, me := range state.Players {
+		home, ok := me.Buildings[me.PrimaryTownHall]
+		if !ok {
+			continue
+		}
+		switch {
+		case tick == 0:
+			for id := range me.Builders {
+				queue(t, s, pid, "gather", sim.GatherCommand{ID: id})
+			}
+			queue(t, s, pid, "trainUnit", sim.TrainUnitCommand{UnitType: "builder"})
+		case tick == 200:
+			pos := sim.Float3{X: float64(home.Position.X + 8), Z: float64(home.Position.Z)}
+			queue(t, s, pid, "placeBuilding", sim.PlaceBuildingCommand{TYPE: "barracks", POS: pos})
+		case tick >= 600 && tick%100 == 0:
+			queue(t, s, pid, "trainUnit", sim.TrainUnitCommand{UnitType: "knight"})
+			next := state.Players[pid%sim.PlayerID(len(state.Players))+1]
+			target, ok := next.Buildings[next.PrimaryTownHall]
+			if !ok {
+				continue
+			}
+			for id := range me.Fighters {
+				queue(t, s, pid, "moveUnit", sim.MoveTroopCommand{ID: id, POS: target.GetPosition(), TYPE: "aggro"})
+			}
+		}
+	}
+}
+
+// The same seed and commands have to give the same match, tick for tick, or
+// replays, delayed spectators and lockstep clients all fall apart.
+func TestSimulationIsDeterministic(t *testing.T) {
+	const seed = 3
+	first := newMatch(t, seed)
+	var hashes [][sha256.Size]byte
+	for first.Result() == nil {
+		play(t, first)
+		first.Step()
+		hashes = append(hashes, stateHash(t, first))
+	}
+	killed := 0
+	for _, stats := range first.Result().Stats {
+		killed += stats.UnitsKilled
+	}
+	if killed == 0 {
+		t.Fatalf("nobody fought, so the match didn't test much")
+	}
+
+	second := newMatch(t, seed)
+	commands := first.Log()
+	for tick, want := range hashes {
+		for len(commands) > 0 && commands[0].Tick == tick {
+			if err := second.Queue(commands[0].Player, commands[0].Key, commands[0].Args); err != nil {
+				t.Fatal(err)
+			}
+			commands = commands[1:]
+		}
+		second.Step()
+		if got := stateHash(t, second); got != want {
+			t.Fatalf("the matches went different ways on tick %v", tick)
+		}
+	}
+	if first, second := first.Result(), second.Result(); !reflect.DeepEqual(first, second) {
+		t.Errorf("the first match ended %+v and the second %+v", first, second)
+	}
+}
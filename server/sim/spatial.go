@@ -0,0 +1,249 @@
+package sim
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// spatialCellSize is the width, in world units, of one bucket of a
+// spatialGrid. It is close to aggroRadius so an aggro check touches at most
+// a 3x3 block of cells.
+const spatialCellSize = 8
+
+type spatialEntry struct {
+	id     EntityID
+	owner  PlayerID
+	pos    Float3
+	radius float64
+}
+
+// A spatialGrid buckets entities into square cells on the X/Z plane so
+// proximity queries only look at nearby cells instead of every entity.
+// The cells are a flat slice covering minCell to maxCell, which grows to
+// take in wherever anything is put, so looking at an empty cell costs
+// next to nothing.
+type spatialGrid struct {
+	cells   [][]spatialEntry
+	entries map[EntityID]spatialEntry
+	minCell GridLocation
+	maxCell GridLocation
+}
+
+func newSpatialGrid() *spatialGrid {
+	return &spatialGrid{entries: make(map[EntityID]spatialEntry)}
+}
+
+func spatialCell(pos Float3) GridLocation {
+	return GridLocation{
+		X: int(math.Floor(pos.X / spatialCellSize)),
+		Z: int(math.Floor(pos.Z / spatialCellSize)),
+	}
+}
+
+// index is where cell is in cells, or -1 if it's out of bounds.
+func (s *spatialGrid) index(cell GridLocation) int {
+	if s.cells == nil || cell.X < s.minCell.X || cell.X > s.maxCell.X || cell.Z < s.minCell.Z || cell.Z > s.maxCell.Z {
+		return -1
+	}
+	width := s.maxCell.X - s.minCell.X + 1
+	return (cell.Z-s.minCell.Z)*width + cell.X - s.minCell.X
+}
+
+// grow widens the bounds to take in cell, moving every cell over.
+func (s *spatialGrid) grow(cell GridLocation) {
+	old, oldMin, oldMax := s.cells, s.minCell, s.maxCell
+	if old == nil {
+		oldMin, oldMax = cell, cell
+	}
+	s.minCell = GridLocation{min(oldMin.X, cell.X), min(oldMin.Z, cell.Z)}
+	s.maxCell = GridLocation{max(oldMax.X, cell.X), max(oldMax.Z, cell.Z)}
+	s.cells = make([][]spatialEntry, (s.maxCell.X-s.minCell.X+1)*(s.maxCell.Z-s.minCell.Z+1))
+	if old == nil {
+		return
+	}
+	oldWidth := oldMax.X - oldMin.X + 1
+	for i, entries := range old {
+		s.cells[s.index(GridLocation{oldMin.X + i%oldWidth, oldMin.Z + i/oldWidth})] = entries
+	}
+}
+
+func (s *spatialGrid) insert(id EntityID, owner PlayerID, pos Float3, radius float64) {
+	s.remove(id)
+	cell := spatialCell(pos)
+	if s.index(cell) < 0 {
+		s.grow(cell)
+	}
+	entry := spatialEntry{id, owner, pos, radius}
+	i := s.index(cell)
+	s.cells[i] = append(s.cells[i], entry)
+	s.entries[id] = entry
+}
+
+func (s *spatialGrid) move(id EntityID, pos Float3) {
+	entry, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	if cell := spatialCell(entry.pos); cell == spatialCell(pos) {
+		entry.pos = pos
+		s.entries[id] = entry
+		cell := s.cells[s.index(cell)]
+		cell[indexInCell(cell, id)] = entry
+		return
+	}
+	s.insert(id, entry.owner, pos, entry.radius)
+}
+
+func (s *spatialGrid) remove(id EntityID) {
+	entry, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	i := s.index(spatialCell(entry.pos))
+	cell := s.cells[i]
+	last := len(cell) - 1
+	cell[indexInCell(cell, id)] = cell[last]
+	s.cells[i] = cell[:last]
+	delete(s.entries, id)
+}
+
+// indexInCell finds an entry in its cell. Cells hold a handful of entries,
+// so looking through them beats keeping another index up to date.
+func indexInCell(cell []spatialEntry, id EntityID) int {
+	return slices.IndexFunc(cell, func(e spatialEntry) bool {
+		return e.id == id
+	})
+}
+
+// scanCell calls visit for every entry in cell.
+func (s *spatialGrid) scanCell(cell GridLocation, visit func(spatialEntry)) {
+	i := s.index(cell)
+	if i < 0 {
+		return
+	}
+	for _, e := range s.cells[i] {
+		visit(e)
+	}
+}
+
+// closer reports whether a at distance da beats b at distance db. Ties go
+// to the lower ID so results don't depend on map order.
+func closer(a EntityID, da float64, b EntityID, db float64) bool {
+	return da < db || (da == db && a < b)
+}
+
+// around returns every entry within radius of pos, ordered by ID.
+func (s *spatialGrid) around(pos Float3, radius float64) []spatialEntry {
+	low := spatialCell(pos.subtract(Float3{radius, 0, radius}))
+	high := spatialCell(pos.add(Float3{radius, 0, radius}))
+
+	var found []spatialEntry
+	for x := low.X; x <= high.X; x++ {
+		for z := low.Z; z <= high.Z; z++ {
+			s.scanCell(GridLocation{x, z}, func(e spatialEntry) {
+				if e.pos.subtract(pos).length() <= radius {
+					found = append(found, e)
+				}
+			})
+		}
+	}
+	slices.SortFunc(found, func(a, b spatialEntry) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return found
+}
+
+// within returns the closest entry accepted by accept that lies within
+// radius of pos. It runs for every idle fighter on every tick, so unlike
+// around it doesn't collect or sort anything; closer settles ties.
+func (s *spatialGrid) within(pos Float3, radius float64, accept func(spatialEntry) bool) (spatialEntry, bool) {
+	low := spatialCell(pos.subtract(Float3{radius, 0, radius}))
+	high := spatialCell(pos.add(Float3{radius, 0, radius}))
+
+	var best spatialEntry
+	bestDistance := math.Inf(1)
+	found := false
+	for x := low.X; x <= high.X; x++ {
+		for z := low.Z; z <= high.Z; z++ {
+			s.scanCell(GridLocation{x, z}, func(e spatialEntry) {
+				distance := e.pos.subtract(pos).length()
+				if distance > radius || !accept(e) {
+					return
+				}
+				if !found || closer(e.id, distance, best.id, bestDistance) {
+					best, bestDistance, found = e, distance, true
+				}
+			})
+		}
+	}
+	return best, found
+}
+
+// nearest returns the closest entry accepted by accept, however far away it
+// is. It searches outward one ring of cells at a time and stops as soon as
+// no unsearched cell could hold anything closer.
+func (s *spatialGrid) nearest(pos Float3, accept func(spatialEntry) bool) (spatialEntry, bool) {
+	center := spatialCell(pos)
+	maxRing := max(
+		abs(center.X-s.minCell.X), abs(center.X-s.maxCell.X),
+		abs(center.Z-s.minCell.Z), abs(center.Z-s.maxCell.Z),
+	)
+
+	var best spatialEntry
+	bestDistance := math.Inf(1)
+	found := false
+	visit := func(e spatialEntry) {
+		if !accept(e) {
+			return
+		}
+		distance := e.pos.subtract(pos).length()
+		if !found || closer(e.id, distance, best.id, bestDistance) {
+			best, bestDistance, found = e, distance, true
+		}
+	}
+	for ring := 0; ring <= maxRing; ring++ {
+		s.scanRing(center, ring, visit)
+		// Every cell in the next ring is at least ring cells away.
+		if found && bestDistance <= float64(ring*spatialCellSize) {
+			break
+		}
+	}
+	return best, found
+}
+
+// scanRing calls visit for every entry in the cells ring cells away from
+// center, walking just the edge of the square: the top and bottom rows and
+// what's left of the two sides. Only cells inside the grid's bounds can
+// hold anything.
+func (s *spatialGrid) scanRing(center GridLocation, ring int, visit func(spatialEntry)) {
+	if ring == 0 {
+		s.scanCell(center, visit)
+		return
+	}
+	lowX, highX := max(center.X-ring, s.minCell.X), min(center.X+ring, s.maxCell.X)
+	for _, z := range []int{center.Z - ring, center.Z + ring} {
+		if z < s.minCell.Z || z > s.maxCell.Z {
+			continue
+		}
+		for x := lowX; x <= highX; x++ {
+			s.scanCell(GridLocation{x, z}, visit)
+		}
+	}
+	lowZ, highZ := max(center.Z-ring+1, s.minCell.Z), min(center.Z+ring-1, s.maxCell.Z)
+	for _, x := range []int{center.X - ring, center.X + ring} {
+		if x < s.minCell.X || x > s.maxCell.X {
+			continue
+		}
+		for z := lowZ; z <= highZ; z++ {
+			s.scanCell(GridLocation{x, z}, visit)
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
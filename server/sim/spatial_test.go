@@ -0,0 +1,145 @@
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// A crowd is a grid and the same entries in a plain list, for checking the
+// grid against a scan of everything.
+type crowd struct {
+	grid    *spatialGrid
+	entries []spatialEntry
+}
+
+// newCrowd scatters n entries owned by players 1 to owners over a size by
+// size map.
+func newCrowd(rng *rand.Rand, n int, owners int, size float64) *crowd {
+	c := &crowd{grid: newSpatialGrid()}
+	for i := range n {
+		e := spatialEntry{
+			id:     EntityID(i + 1),
+			owner:  PlayerID(rng.Intn(owners) + 1),
+			pos:    Float3{X: rng.Float64() * size, Z: rng.Float64() * size},
+			radius: 0.5,
+		}
+		c.grid.insert(e.id, e.owner, e.pos, e.radius)
+		c.entries = append(c.entries, e)
+	}
+	return c
+}
+
+// scan is what the grid saves doing: look at every entry for the closest
+// one accepted within radius.
+func (c *crowd) scan(pos Float3, radius float64, accept func(spatialEntry) bool) (spatialEntry, bool) {
+	var best spatialEntry
+	bestDistance := radius
+	found := false
+	for _, e := range c.entries {
+		if !accept(e) {
+			continue
+		}
+		distance := e.pos.subtract(pos).length()
+		if distance <= bestDistance && (!found || closer(e.id, distance, best.id, bestDistance)) {
+			best, bestDistance, found = e, distance, true
+		}
+	}
+	return best, found
+}
+
+func enemyOf(owner PlayerID) func(spatialEntry) bool {
+	return func(e spatialEntry) bool { return e.owner != owner }
+}
+
+func TestSpatialGridMatchesScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	c := newCrowd(rng, 400, 4, 160)
+	// Some of the crowd moves, some of it across cells, and some dies.
+	for i := range c.entries {
+		switch i % 4 {
+		case 0:
+			c.entries[i].pos = c.entries[i].pos.add(Float3{X: rng.Float64()*20 - 10, Z: rng.Float64()*20 - 10})
+			c.grid.move(c.entries[i].id, c.entries[i].pos)
+		case 1:
+			c.grid.remove(c.entries[i].id)
+		}
+	}
+	alive := c.entries[:0]
+	for i, e := range c.entries {
+		if i%4 != 1 {
+			alive = append(alive, e)
+		}
+	}
+	c.entries = alive
+
+	for range 2000 {
+		pos := Float3{X: rng.Float64()*200 - 20, Z: rng.Float64()*200 - 20}
+		owner := PlayerID(rng.Intn(4) + 1)
+		accept := enemyOf(owner)
+
+		want, wantFound := c.scan(pos, aggroRadius, accept)
+		got, gotFound := c.grid.within(pos, aggroRadius, accept)
+		if got != want || gotFound != wantFound {
+			t.Fatalf("within(%v, %v) for player %v = %v, %v; scan found %v, %v", pos, aggroRadius, owner, got, gotFound, want, wantFound)
+		}
+
+		want, wantFound = c.scan(pos, 1e9, accept)
+		got, gotFound = c.grid.nearest(pos, accept)
+		if got != want || gotFound != wantFound {
+			t.Fatalf("nearest(%v) for player %v = %v, %v; scan found %v, %v", pos, owner, got, gotFound, want, wantFound)
+		}
+	}
+}
+
+// BenchmarkClosestEnemy is one tick's worth of aggro checks: every unit in
+// an eight player game looking for an enemy in range.
+func BenchmarkClosestEnemy(b *testing.B) {
+	for _, units := range []int{100, 400, 1600} {
+		c := newCrowd(rand.New(rand.NewSource(1)), units, 8, 160)
+		b.Run(fmt.Sprintf("grid/%v", units), func(b *testing.B) {
+			for b.Loop() {
+				for _, e := range c.entries {
+					c.grid.within(e.pos, aggroRadius, enemyOf(e.owner))
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("scan/%v", units), func(b *testing.B) {
+			for b.Loop() {
+				for _, e := range c.entries {
+					c.scan(e.pos, aggroRadius, enemyOf(e.owner))
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNearestResource is a tick's worth of builders each looking for
+// the nearest node of one type, wherever it is.
+func BenchmarkNearestResource(b *testing.B) {
+	const builders = 80
+	for _, nodes := range []int{50, 100, 200, 800} {
+		rng := rand.New(rand.NewSource(1))
+		// The owner stands in for the resource type.
+		c := newCrowd(rng, nodes, 3, 160)
+		var from []Float3
+		for range builders {
+			from = append(from, Float3{X: rng.Float64() * 160, Z: rng.Float64() * 160})
+		}
+		ofType := func(e spatialEntry) bool { return e.owner == 1 }
+		b.Run(fmt.Sprintf("grid/%v", nodes), func(b *testing.B) {
+			for b.Loop() {
+				for _, pos := range from {
+					c.grid.nearest(pos, ofType)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("scan/%v", nodes), func(b *testing.B) {
+			for b.Loop() {
+				for _, pos := range from {
+					c.scan(pos, 1e9, ofType)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package sim
+
+import "math"
+
+// separationRate is the fraction of an overlap a unit resolves per second.
+// One tick never resolves more than half of it, since the other unit moves
+// out of the way too.
+const separationRate = 10
+
+// arrivalRadius is how close to its goal a unit must be to settle down
+// next to units already standing there instead of shoving into them.
+const arrivalRadius = 3
+
+// separate nudges a unit away from any other unit it overlaps, going by the
+// radius each unit type has in the catalog, so groups spread out around a
+// shared goal instead of stacking on one point. A unit on the last leg to
+// a crowded goal stops once it bumps into someone who has already arrived.
+func (g *Game) separate(id EntityID, m Movable, dt float64) {
+	position := m.GetPosition()
+	radius := m.getRadius()
+
+	push := Float3{}
+	bumpedArrived := false
+	for _, other := range g.unitIndex.around(position, radius+g.catalog.maxUnitRadius) {
+		if other.id == id || other.radius == 0 {
+			continue
+		}
+		offset := position.subtract(other.pos)
+		offset.Y = 0
+		distance := offset.length()
+		overlap := radius + other.radius - distance
+		if overlap <= 0 {
+			continue
+		}
+		var away Float3
+		if distance == 0 {
+			// Exactly on top of each other: split along a direction
+			// picked from the IDs so the result stays deterministic.
+			angle := float64(id-other.id) * math.Pi / 4
+			away = Float3{math.Cos(angle), 0, math.Sin(angle)}
+		} else {
+			away = offset.scale(1 / distance)
+		}
+		push = push.add(away.scale(overlap))
+
+		if neighbor := g.getMovable(other.id); neighbor != nil && len(neighbor.getPath().waypoints) == 0 {
+			bumpedArrived = true
+		}
+	}
+	if push.length() == 0 {
+		return
+	}
+
+	moved := position.add(push.scale(min(separationRate*dt, 0.5)))
+	if !g.isBlocked(float3ToGridLocation(moved)) {
+		m.SetPosition(moved)
+	}
+
+	path := m.getPath()
+	goal := m.GetGoalPosition()
+	if bumpedArrived && len(path.waypoints) == 1 && m.GetPosition().subtract(goal).length() < arrivalRadius {
+		m.SetGoalPosition(m.GetPosition())
+		path.waypoints = path.waypoints[:0]
+		path.goal = m.GetPosition()
+	}
+}
+
+// approachPoint is where a fighter should head to get in range of target:
+// just inside its attack range, on the side the fighter is coming from.
+// Attackers coming from different directions surround the target instead
+// of queueing up on one spot.
+func (f *Fighter) approachPoint(target Killable) Float3 {
+	targetPosition := target.GetPosition()
+	offset := f.Position.subtract(targetPosition)
+	offset.Y = 0
+	if offset.length() == 0 {
+		return targetPosition
+	}
+	return targetPosition.add(offset.normalize().scale(f.AreaOfAttack * 0.8))
+}
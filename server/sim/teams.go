@@ -0,0 +1,60 @@
+package sim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Players on the same nonzero team are allies: their units leave each other
+// alone and they win or lose together. Team 0 means playing alone.
+func (g *Game) allied(a, b PlayerID) bool {
+	if a == b {
+		return true
+	}
+	pa, okA := g.players[a]
+	pb, okB := g.players[b]
+	return okA && okB && pa.team != 0 && pa.team == pb.team
+}
+
+// side identifies who a player wins or loses with: their team, or just
+// themselves if they have none. Teams are positive and lone players'
+// sides negative so the two never collide.
+func (p *Player) side() int {
+	if p.team != 0 {
+		return p.team
+	}
+	return -p.id
+}
+
+// ParseTeams reads a comma-separated team for each player in base order,
+// e.g. "1,2,1,2", as MapChoice.Teams takes them.
+func ParseTeams(s string) ([]int, error) {
+	var teams []int
+	for _, name := range strings.Split(s, ",") {
+		team, err := strconv.Atoi(name)
+		if err != nil {
+			return nil, fmt.Errorf("bad team %q", name)
+		}
+		teams = append(teams, team)
+	}
+	return teams, nil
+}
+
+// applyTeams assigns teams on the map, one per player in base order.
+// Without any the map's own teams stand.
+func applyTeams(teams []int, m *Map) error {
+	if teams == nil {
+		return nil
+	}
+	if len(teams) != len(m.Bases) {
+		return fmt.Errorf("%v teams for %v players", len(teams), len(m.Bases))
+	}
+	for i, team := range teams {
+		if team < 0 || team > maxPlayers {
+			return fmt.Errorf("bad team %v", team)
+		}
+		m.Bases[i].Team = team
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package sim
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// VictoryConditions are the ways a match can end. A player is out as soon
+// as any enabled elimination condition holds for them, and the last player
+// or team left wins. With a time limit the match also ends when time runs
+// out, and the highest score wins, adding up the scores of teammates.
+type VictoryConditions struct {
+	// Out once every town hall is destroyed.
+	TownHalls bool `json:"townHalls"`
+	// Out once every unit is dead.
+	Units bool `json:"units"`
+	// Seconds of game time, or 0 for no limit.
+	TimeLimit float64 `json:"timeLimit"`
+}
+
+// DefaultVictoryConditions is how games are won unless they say otherwise:
+// by destroying every enemy town hall.
+func DefaultVictoryConditions() VictoryConditions {
+	return VictoryConditions{TownHalls: true}
+}
+
+// ParseVictoryConditions reads the elimination conditions from a
+// comma-separated list of their names, townHalls and units, e.g.
+// "townHalls,units", and takes the time limit in seconds as it is. This is
+// how the server's /start requests and the simulator's flags spell them.
+func ParseVictoryConditions(names string, timeLimit float64) (VictoryConditions, error) {
+	conditions := VictoryConditions{TimeLimit: timeLimit}
+	for _, name := range strings.Split(names, ",") {
+		switch name {
+		case "townHalls":
+			conditions.TownHalls = true
+		case "units":
+			conditions.Units = true
+		case "":
+		default:
+			return conditions, fmt.Errorf("unknown victory condition %q", name)
+		}
+	}
+	return conditions, conditions.Check()
+}
+
+// Check reports whether the conditions can ever end a match.
+func (v VictoryConditions) Check() error {
+	if !v.TownHalls && !v.Units && v.TimeLimit <= 0 {
+		return fmt.Errorf("no way for the match to end")
+	}
+	if v.TimeLimit < 0 {
+		return fmt.Errorf("bad time limit %v", v.TimeLimit)
+	}
+	return nil
+}
+
+// PlayerStats is a running tally of how a player's match went.
+type PlayerStats struct {
+	ResourcesGathered  float64 `json:"resourcesGathered"`
+	UnitsTrained       int     `json:"unitsTrained"`
+	UnitsLost          int     `json:"unitsLost"`
+	UnitsKilled        int     `json:"unitsKilled"`
+	BuildingsBuilt     int     `json:"buildingsBuilt"`
+	BuildingsLost      int     `json:"buildingsLost"`
+	BuildingsDestroyed int     `json:"buildingsDestroyed"`
+	// Total cost of the enemy units and buildings this player destroyed.
+	ValueDestroyed float64 `json:"valueDestroyed"`
+	Score          float64 `json:"score"`
+	Defeated       bool    `json:"defeated"`
+	// Seconds of game time until the player first finished each type of
+	// building, not counting the ones they started with.
+	FirstBuilt map[string]float64 `json:"firstBuilt"`
+}
+
+// A GameOver is sent to every client once the match is decided.
+type GameOver struct {
+	Type string `json:"type"`
+	// The winning player, or 0 for a draw or a team win.
+	Winner      PlayerID                 `json:"winner"`
+	Reason      string                   `json:"reason"`
+	ElapsedTime float64                  `json:"elapsedTime"`
+	Stats       map[PlayerID]PlayerStats `json:"stats"`
+
+	// Everyone on the winning side, including teammates who were already
+	// out, and their team if they had one. Empty for a draw.
+	Winners []PlayerID `json:"winners"`
+	Team    int        `json:"team,omitempty"`
+}
+
+func (c Cost) total() float64 {
+	return c.Gold + c.Stone + c.Wood
+}
+
+// creditKill records that killer destroyed target.
+func (g *Game) creditKill(killer PlayerID, target Killable) {
+	player, ok := g.players[killer]
+	if !ok {
+		return
+	}
+	switch target := target.(type) {
+	case *Building:
+		player.stats.BuildingsDestroyed++
+		player.stats.ValueDestroyed += target.def.Cost.total()
+	case *Fighter:
+		player.stats.UnitsKilled++
+		player.stats.ValueDestroyed += target.def.Cost.total()
+	case *Builder:
+		player.stats.UnitsKilled++
+		player.stats.ValueDestroyed += target.def.Cost.total()
+	}
+}
+
+// isEliminated reports whether any enabled elimination condition holds for
+// the player.
+func (g *Game) isEliminated(player *Player) bool {
+	if g.victory.TownHalls {
+		hasTownHall := false
+		for _, building := range player.buildings {
+			if building.BuildingType == "townhall" {
+				hasTownHall = true
+				break
+			}
+		}
+		if !hasTownHall {
+			return true
+		}
+	}
+	if g.victory.Units && len(player.fighters) == 0 && len(player.builders) == 0 {
+		return true
+	}
+	return false
+}
+
+// checkVictory marks newly eliminated players as defeated and ends the game
+// once only one side is left, nobody is, or the time limit has run out.
+func (g *Game) checkVictory() {
+	if g.over != nil {
+		return
+	}
+	// The sides still standing, in order of their first player.
+	var remaining []int
+	for _, pid := range sortedKeys(g.players) {
+		player := g.players[pid]
+		if !player.stats.Defeated && g.isEliminated(player) {
+			player.stats.Defeated = true
+		}
+		if !player.stats.Defeated && !slices.Contains(remaining, player.side()) {
+			remaining = append(remaining, player.side())
+		}
+	}
+
+	switch {
+	case len(remaining) == 0:
+		g.endGame(0, "elimination")
+	case len(remaining) == 1 && g.sides() > 1:
+		g.endGame(remaining[0], "elimination")
+	case g.victory.TimeLimit > 0 && g.elapsedTime >= g.victory.TimeLimit:
+		// The highest score among the sides still standing wins; a tie is
+		// a draw.
+		winner := 0
+		best := -1.0
+		for _, side := range remaining {
+			score := g.sideScore(side)
+			if score > best {
+				winner, best = side, score
+			} else if score == best {
+				winner = 0
+			}
+		}
+		g.endGame(winner, "timeLimit")
+	}
+}
+
+// sides counts the teams and lone players in the game.
+func (g *Game) sides() int {
+	seen := make(map[int]bool)
+	for _, player := range g.players {
+		seen[player.side()] = true
+	}
+	return len(seen)
+}
+
+// sideScore adds up the scores of everyone on a side.
+func (g *Game) sideScore(side int) float64 {
+	score := 0.0
+	// Summed in player order, since floating point addition isn't
+	// associative and map order would make a close call come out either way.
+	for _, pid := range sortedKeys(g.players) {
+		if player := g.players[pid]; player.side() == side {
+			score += player.score()
+		}
+	}
+	return score
+}
+
+func (p *Player) score() float64 {
+	return p.stats.ResourcesGathered + p.stats.ValueDestroyed
+}
+
+// endGame ends the match in a win for side, or a draw if side is 0.
+func (g *Game) endGame(side int, reason string) {
+	stats := make(map[PlayerID]PlayerStats)
+	for pid, player := range g.players {
+		player.stats.Score = player.score()
+		stats[pid] = player.stats
+	}
+	g.over = &GameOver{
+		Type:        "gameOver",
+		Reason:      reason,
+		ElapsedTime: g.elapsedTime,
+		Stats:       stats,
+		Winners:     []PlayerID{},
+	}
+	if side == 0 {
+		return
+	}
+	for _, pid := range sortedKeys(g.players) {
+		if g.players[pid].side() == side {
+			g.over.Winners = append(g.over.Winners, pid)
+		}
+	}
+	if side > 0 {
+		g.over.Team = side
+	} else {
+		g.over.Winner = PlayerID(-side)
+	}
+}
@@ -0,0 +1,300 @@
+package sim
+
+import "math"
+
+// A vision is what one side of the match (a team, or a player on their own)
+// can see. Tiles within sight of any of the side's units or buildings are
+// visible. Enemy buildings and resource nodes are remembered as they were
+// last seen, so they stay on the side's map after their tiles go dark.
+type vision struct {
+	visible map[GridLocation]bool
+
+	buildings map[EntityID]rememberedBuilding
+	resources map[EntityID]Resource
+
+	// The side's own entities and the enemy ones in sight, this tick and
+	// the last, so the side hears about the deaths it could see.
+	seen    map[EntityID]bool
+	wasSeen map[EntityID]bool
+}
+
+type rememberedBuilding struct {
+	owner    PlayerID
+	building Building
+}
+
+// newVision starts a side off knowing the map's resource nodes, which are
+// on the map everyone gets, but nothing about what's become of them.
+func (g *Game) newVision() *vision {
+	v := &vision{
+		visible:   make(map[GridLocation]bool),
+		buildings: make(map[EntityID]rememberedBuilding),
+		resources: make(map[EntityID]Resource),
+		seen:      make(map[EntityID]bool),
+	}
+	for rid, resource := range g.resources {
+		v.resources[rid] = *resource
+	}
+	return v
+}
+
+// footprintCenter is the middle of a footprint.
+func footprintCenter(pos GridLocation, size int) Float3 {
+	low, _ := footprint(pos, size)
+	half := float64(size) / 2
+	return Float3{X: float64(low.X) + half, Z: float64(low.Z) + half}
+}
+
+// reveal marks every tile whose center is within radius of p as visible.
+func (v *vision) reveal(p Float3, radius float64) {
+	low := float3ToGridLocation(p.subtract(Float3{X: radius, Z: radius}))
+	high := float3ToGridLocation(p.add(Float3{X: radius, Z: radius}))
+	for x := low.X; x <= high.X; x++ {
+		for z := low.Z; z <= high.Z; z++ {
+			tile := GridLocation{x, z}
+			center := tileCenter(tile, 0)
+			if math.Hypot(center.X-p.X, center.Z-p.Z) <= radius {
+				v.visible[tile] = true
+			}
+		}
+	}
+}
+
+// seesFootprint reports whether any tile of a footprint is visible.
+func (v *vision) seesFootprint(pos GridLocation, size int) bool {
+	low, high := footprint(pos, size)
+	for x := low.X; x <= high.X; x++ {
+		for z := low.Z; z <= high.Z; z++ {
+			if v.visible[GridLocation{x, z}] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *vision) seesUnit(p Float3) bool {
+	return v.visible[float3ToGridLocation(p)]
+}
+
+// canSee reports whether a player's side had an entity in sight at the end
+// of the last tick. Their own side's entities are always in sight.
+func (g *Game) canSee(pid PlayerID, id EntityID) bool {
+	player, ok := g.players[pid]
+	if !ok {
+		return false
+	}
+	return g.visions[player.side()].seen[id]
+}
+
+// visionOf is the vision of a player's side.
+func (g *Game) visionOf(pid PlayerID) *vision {
+	return g.visions[g.players[pid].side()]
+}
+
+// knowsBlocked reports whether a side knows a tile to be taken. Tiles in
+// sight are as they are. Out of sight, the side only knows about the
+// terrain, which is on everyone's map, its own buildings, and the enemy
+// buildings and resource nodes it remembers there.
+func (g *Game) knowsBlocked(side int, tile GridLocation) bool {
+	v := g.visions[side]
+	if v.visible[tile] {
+		return g.isBlocked(tile)
+	}
+	for _, terrain := range g.gameMap.Terrain {
+		if terrain.Position == tile {
+			return true
+		}
+	}
+	inside := func(pos GridLocation, size int) bool {
+		low, high := footprint(pos, size)
+		return tile.X >= low.X && tile.X <= high.X && tile.Z >= low.Z && tile.Z <= high.Z
+	}
+	for _, pid := range sortedKeys(g.players) {
+		player := g.players[pid]
+		if player.side() != side {
+			continue
+		}
+		for _, building := range player.buildings {
+			if inside(building.Position, building.size()) {
+				return true
+			}
+		}
+	}
+	for _, memory := range v.buildings {
+		if inside(memory.building.Position, memory.building.def.Size) {
+			return true
+		}
+	}
+	for _, resource := range v.resources {
+		if resource.tile() == tile {
+			return true
+		}
+	}
+	return false
+}
+
+// knownResources is a side's picture of the resource nodes: the ones in
+// sight as they are and the rest as they were last seen.
+func (g *Game) knownResources(side int) map[EntityID]*Resource {
+	known := make(map[EntityID]*Resource)
+	for rid, resource := range g.visions[side].resources {
+		known[rid] = &resource
+	}
+	return known
+}
+
+// updateVisions recomputes what every side can see at the end of a tick.
+func (g *Game) updateVisions() {
+	for _, side := range sortedKeys(g.visions) {
+		g.updateVision(side, g.visions[side])
+	}
+}
+
+func (g *Game) updateVision(side int, v *vision) {
+	clear(v.visible)
+	v.wasSeen, v.seen = v.seen, make(map[EntityID]bool)
+	for _, pid := range sortedKeys(g.players) {
+		player := g.players[pid]
+		if player.side() != side {
+			continue
+		}
+		for _, fighter := range player.fighters {
+			v.reveal(fighter.Position, fighter.def.Sight)
+		}
+		for _, builder := range player.builders {
+			v.reveal(builder.Position, builder.def.Sight)
+		}
+		for _, building := range player.buildings {
+			v.reveal(footprintCenter(building.Position, building.size()), building.def.Sight)
+		}
+	}
+
+	// Remembered buildings and nodes that are in sight but gone are
+	// forgotten; the rest are refreshed.
+	for _, id := range sortedKeys(v.buildings) {
+		memory := v.buildings[id]
+		if v.seesFootprint(memory.building.Position, memory.building.def.Size) {
+			delete(v.buildings, id)
+		}
+	}
+	for _, id := range sortedKeys(v.resources) {
+		resource := v.resources[id]
+		if v.visible[resource.tile()] {
+			delete(v.resources, id)
+		}
+	}
+	for _, pid := range sortedKeys(g.players) {
+		player := g.players[pid]
+		own := player.side() == side
+		for fid, fighter := range player.fighters {
+			if own || v.seesUnit(fighter.Position) {
+				v.seen[fid] = true
+			}
+		}
+		for bid, builder := range player.builders {
+			if own || v.seesUnit(builder.Position) {
+				v.seen[bid] = true
+			}
+		}
+		for bid, building := range player.buildings {
+			if own {
+				v.seen[bid] = true
+			} else if v.seesFootprint(building.Position, building.size()) {
+				v.seen[bid] = true
+				v.buildings[bid] = rememberedBuilding{owner: pid, building: building.snapshot()}
+			}
+		}
+	}
+	for rid, resource := range g.resources {
+		if v.visible[resource.tile()] {
+			v.resources[rid] = *resource
+		}
+	}
+}
+
+// GetStates splits the full state into what each player can see.
+// Spectators, as player 0, get the full state.
+func (g *Game) GetStates() map[PlayerID]GameState {
+	full := g.GetState()
+	states := map[PlayerID]GameState{0: full}
+	for pid := range g.players {
+		states[pid] = g.StateFor(full, pid)
+	}
+	return states
+}
+
+// StateFor is the part of the full state a player's side can see: all of
+// their own side's units and buildings, the enemy units in sight, and
+// enemy buildings and resource nodes as they were last seen. Enemy
+// stockpiles, production queues and rally points are hidden, and so is
+// where enemy units are headed and what they're after.
+func (g *Game) StateFor(full GameState, pid PlayerID) GameState {
+	side := g.players[pid].side()
+	v := g.visions[side]
+	state := GameState{
+		ElapsedTime: full.ElapsedTime,
+		Deceased:    []EntityID{},
+		Events:      []ResourceEvent{},
+		Players:     make(map[PlayerID]PlayerState),
+		Resources:   make(map[EntityID]Resource),
+	}
+	for _, id := range full.Deceased {
+		if v.wasSeen[id] {
+			state.Deceased = append(state.Deceased, id)
+		}
+	}
+	for _, event := range full.Events {
+		if tile, _ := footprint(event.Position, resourceSize); v.visible[tile] {
+			state.Events = append(state.Events, event)
+		}
+	}
+	for id, resource := range v.resources {
+		state.Resources[id] = resource
+	}
+
+	for opid, player := range full.Players {
+		if g.players[opid].side() == side {
+			state.Players[opid] = player
+			continue
+		}
+		enemy := PlayerState{
+			Id:        player.Id,
+			Fighters:  make(map[EntityID]Fighter),
+			Builders:  make(map[EntityID]Builder),
+			Buildings: make(map[EntityID]Building),
+
+			PrimaryTownHall: -1,
+			Team:            player.Team,
+		}
+		for fid, fighter := range player.Fighters {
+			if v.seen[fid] {
+				fighter.GoalPosition = fighter.Position
+				fighter.TargetEntityId = -1
+				enemy.Fighters[fid] = fighter
+			}
+		}
+		for bid, builder := range player.Builders {
+			if v.seen[bid] {
+				builder.GoalPosition = builder.Position
+				builder.ResourceTarget = nil
+				builder.Order = BuilderOrder{TargetID: -1}
+				enemy.Builders[bid] = builder
+			}
+		}
+		for bid, memory := range v.buildings {
+			if memory.owner != opid {
+				continue
+			}
+			building := memory.building
+			building.Queue = nil
+			building.RallyPoint = nil
+			enemy.Buildings[bid] = building
+			if bid == player.PrimaryTownHall {
+				enemy.PrimaryTownHall = bid
+			}
+		}
+		state.Players[opid] = enemy
+	}
+	return state
+}
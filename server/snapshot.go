@@ -0,0 +1,131 @@
+package main
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+
+	"hackcu2025/sim"
+)
+
+// snapshotHistory is how many past ticks of state a match keeps around to
+// diff against. A client whose last acknowledged tick has fallen out of the
+// history gets a fresh full snapshot instead of a delta.
+const snapshotHistory = 64
+
+// A Snapshot carries the whole game state. Clients get one when they join,
+// when they ask to resync, and whenever they fall too far behind.
+type Snapshot struct {
+	Type  string        `json:"type"`
+	Seq   int           `json:"seq"`
+	State sim.GameState `json:"state"`
+}
+
+// A Delta carries only what changed since the snapshot or delta numbered
+// BaseSeq. Entities listed in Removed no longer exist; everything else in
+// Players and Resources replaces the client's copy wholesale.
+type Delta struct {
+	Type        string                        `json:"type"`
+	Seq         int                           `json:"seq"`
+	BaseSeq     int                           `json:"baseSeq"`
+	ElapsedTime float64                       `json:"elapsedTime"`
+	Deceased    []sim.EntityID                `json:"deceased"`
+	Events      []sim.ResourceEvent           `json:"events,omitempty"`
+	Removed     []sim.EntityID                `json:"removed"`
+	Players     map[sim.PlayerID]PlayerDelta  `json:"players"`
+	Resources   map[sim.EntityID]sim.Resource `json:"resources,omitempty"`
+}
+
+type PlayerDelta struct {
+	Id        int                           `json:"id"`
+	Gold      float64                       `json:"gold"`
+	Stone     float64                       `json:"stone"`
+	Wood      float64                       `json:"wood"`
+	Fighters  map[sim.EntityID]sim.Fighter  `json:"fighters,omitempty"`
+	Builders  map[sim.EntityID]sim.Builder  `json:"builders,omitempty"`
+	Buildings map[sim.EntityID]sim.Building `json:"buildings,omitempty"`
+
+	PrimaryTownHall sim.EntityID `json:"primaryTownHall"`
+	Team            int          `json:"team"`
+}
+
+type stateRecord struct {
+	seq   int
+	state sim.GameState
+}
+
+// A stateHistory is a ring buffer of the most recent game states, keyed by
+// the tick they were taken on.
+type stateHistory struct {
+	records [snapshotHistory]stateRecord
+}
+
+func (h *stateHistory) add(seq int, state sim.GameState) {
+	h.records[seq%snapshotHistory] = stateRecord{seq, state}
+}
+
+func (h *stateHistory) get(seq int) (sim.GameState, bool) {
+	if seq < 0 {
+		return sim.GameState{}, false
+	}
+	record := h.records[seq%snapshotHistory]
+	if record.seq != seq || record.state.Players == nil {
+		return sim.GameState{}, false
+	}
+	return record.state, true
+}
+
+// changedEntities returns the entries of cur that are new or differ from
+// base, and appends to removed the IDs that are in base but not in cur.
+func changedEntities[T any](base, cur map[sim.EntityID]T, removed []sim.EntityID) (map[sim.EntityID]T, []sim.EntityID) {
+	var changed map[sim.EntityID]T
+	for id, entity := range cur {
+		old, ok := base[id]
+		if ok && reflect.DeepEqual(old, entity) {
+			continue
+		}
+		if changed == nil {
+			changed = make(map[sim.EntityID]T)
+		}
+		changed[id] = entity
+	}
+	for _, id := range slices.Sorted(maps.Keys(base)) {
+		if _, ok := cur[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	return changed, removed
+}
+
+func diffState(baseSeq int, base sim.GameState, seq int, cur sim.GameState) Delta {
+	delta := Delta{
+		Type:        "delta",
+		Seq:         seq,
+		BaseSeq:     baseSeq,
+		ElapsedTime: cur.ElapsedTime,
+		Deceased:    cur.Deceased,
+		Events:      cur.Events,
+		Removed:     []sim.EntityID{},
+		Players:     make(map[sim.PlayerID]PlayerDelta),
+	}
+
+	for pid, player := range cur.Players {
+		basePlayer := base.Players[pid]
+		pd := PlayerDelta{
+			Id:    player.Id,
+			Gold:  player.Gold,
+			Stone: player.Stone,
+			Wood:  player.Wood,
+
+			PrimaryTownHall: player.PrimaryTownHall,
+			Team:            player.Team,
+		}
+		pd.Fighters, delta.Removed = changedEntities(basePlayer.Fighters, player.Fighters, delta.Removed)
+		pd.Builders, delta.Removed = changedEntities(basePlayer.Builders, player.Builders, delta.Removed)
+		pd.Buildings, delta.Removed = changedEntities(basePlayer.Buildings, player.Buildings, delta.Removed)
+		delta.Players[pid] = pd
+	}
+	delta.Resources, delta.Removed = changedEntities(base.Resources, cur.Resources, delta.Removed)
+	slices.Sort(delta.Removed)
+	return delta
+}
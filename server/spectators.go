@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"hackcu2025/sim"
+)
+
+// Spectators connect with ?spectate=true. They take no seat, there's no
+// limit on how many can watch, and they get the whole unfogged state as
+// player 0. Anything they send besides acks and resyncs is refused.
+const spectator sim.PlayerID = 0
+
+// maxSpectatorDelay is the longest a match can hold spectators back, in
+// seconds.
+const maxSpectatorDelay = 300
+
+// parseSpectatorDelay reads the spectatorDelay parameter of a /start
+// request: how many seconds behind live play spectators watch, so they
+// can't call out what they see to a player.
+func parseSpectatorDelay(query url.Values) (float64, error) {
+	if !query.Has("spectatorDelay") {
+		return 0, nil
+	}
+	delay, err := strconv.ParseFloat(query.Get("spectatorDelay"), 64)
+	if err != nil || delay < 0 || delay > maxSpectatorDelay {
+		return 0, fmt.Errorf("spectator delay must be 0 to %v seconds", maxSpectatorDelay)
+	}
+	return delay, nil
+}
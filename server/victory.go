@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"hackcu2025/sim"
+)
+
+// parseVictoryConditions reads the victory and timeLimit parameters of a
+// /start request, e.g. ?victory=townHalls,units&timeLimit=900. Missing
+// parameters leave the defaults in place.
+func parseVictoryConditions(query url.Values) (sim.VictoryConditions, error) {
+	conditions := sim.DefaultVictoryConditions()
+	if query.Has("timeLimit") {
+		limit, err := strconv.ParseFloat(query.Get("timeLimit"), 64)
+		if err != nil || limit < 0 {
+			return conditions, fmt.Errorf("bad time limit %q", query.Get("timeLimit"))
+		}
+		conditions.TimeLimit = limit
+	}
+	if query.Has("victory") {
+		return sim.ParseVictoryConditions(query.Get("victory"), conditions.TimeLimit)
+	}
+	return conditions, conditions.Check()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"hackcu2025/sim"
+)
+
+// Text commands are what players type into the terminal. Each one becomes
+// one of the simulation's commands:
+//
+//	move <unit> <x> <y> <z> [aggro]    walk a unit somewhere, fighting on the way with aggro
+//	build <type> <x> <z>               place a building
+//	train <unit type> [building]       queue a unit, at the shortest queue if no building is given
+//	attack <unit> <target>             attack an enemy unit or building
+//	gather <builder> [resource type]   send a builder to the nearest node
+//	construct <builder> <building>     put a builder to work on an unfinished building
+//	repair <builder> <building>        put a builder to work on a damaged building
+//	stop <builder>                     leave a builder idle
+//	rally <building> <x> <z>           set where a building's units go
+//	cancel <building>                  cancel a building that isn't finished
+//	resign                             give up, leaving the game to everyone else
+var textCommands = map[string]func(args []string) (string, any, error){
+	"move": func(args []string) (string, any, error) {
+		if len(args) != 4 && len(args) != 5 {
+			return "", nil, usage("move <unit> <x> <y> <z> [aggro]")
+		}
+		id, err := entityArg(args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		pos, err := float3Arg(args[1], args[2], args[3])
+		if err != nil {
+			return "", nil, err
+		}
+		moveType := ""
+		if len(args) == 5 {
+			if args[4] != "aggro" {
+				return "", nil, usage("move <unit> <x> <y> <z> [aggro]")
+			}
+			moveType = "aggro"
+		}
+		return "moveUnit", sim.MoveTroopCommand{ID: id, POS: pos, TYPE: moveType}, nil
+	},
+	"build": func(args []string) (string, any, error) {
+		if len(args) != 3 {
+			return "", nil, usage("build <type> <x> <z>")
+		}
+		pos, err := float3Arg(args[1], "0", args[2])
+		if err != nil {
+			return "", nil, err
+		}
+		return "placeBuilding", sim.PlaceBuildingCommand{TYPE: args[0], POS: pos}, nil
+	},
+	"train": func(args []string) (string, any, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return "", nil, usage("train <unit type> [building]")
+		}
+		command := sim.TrainUnitCommand{UnitType: args[0]}
+		if len(args) == 2 {
+			id, err := entityArg(args[1])
+			if err != nil {
+				return "", nil, err
+			}
+			command.BuildingID = &id
+		}
+		return "trainUnit", command, nil
+	},
+	"attack": func(args []string) (string, any, error) {
+		if len(args) != 2 {
+			return "", nil, usage("attack <unit> <target>")
+		}
+		attacker, err := entityArg(args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		target, err := entityArg(args[1])
+		if err != nil {
+			return "", nil, err
+		}
+		return "attack", sim.AttackCommand{ATTACKER_ID: attacker, TARGET_ID: target}, nil
+	},
+	"gather": func(args []string) (string, any, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return "", nil, usage("gather <builder> [resource type]")
+		}
+		id, err := entityArg(args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		command := sim.GatherCommand{ID: id}
+		if len(args) == 2 {
+			command.ResourceType = args[1]
+		}
+		return "gather", command, nil
+	},
+	"construct": func(args []string) (string, any, error) {
+		return builderOnBuilding("build", "construct <builder> <building>", args)
+	},
+	"repair": func(args []string) (string, any, error) {
+		return builderOnBuilding("repair", "repair <builder> <building>", args)
+	},
+	"stop": func(args []string) (string, any, error) {
+		if len(args) != 1 {
+			return "", nil, usage("stop <builder>")
+		}
+		id, err := entityArg(args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		return "stop", sim.StopCommand{ID: id}, nil
+	},
+	"rally": func(args []string) (string, any, error) {
+		if len(args) != 3 {
+			return "", nil, usage("rally <building> <x> <z>")
+		}
+		id, err := entityArg(args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		pos, err := float3Arg(args[1], "0", args[2])
+		if err != nil {
+			return "", nil, err
+		}
+		return "setRallyPoint", sim.SetRallyPointCommand{BuildingID: id, POS: pos}, nil
+	},
+	"cancel": func(args []string) (string, any, error) {
+		if len(args) != 1 {
+			return "", nil, usage("cancel <building>")
+		}
+		id, err := entityArg(args[0])
+		if err != nil {
+			return "", nil, err
+		}
+		return "cancelBuilding", sim.CancelBuildingCommand{ID: id}, nil
+	},
+	"resign": func(args []string) (string, any, error) {
+		if len(args) != 0 {
+			return "", nil, usage("resign")
+		}
+		return "resign", sim.ResignCommand{}, nil
+	},
+}
+
+// simCommand turns a text command into the key and arguments the
+// simulation takes. Only the form of the command is checked here; whether
+// the player can actually do it is up to the simulation, on its next tick.
+func (c *Command) simCommand() (string, json.RawMessage, error) {
+	parse, ok := textCommands[c.Operation]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown command %q", c.Operation)
+	}
+	key, command, err := parse(c.Args)
+	if err != nil {
+		return "", nil, err
+	}
+	args, err := json.Marshal(command)
+	return key, args, err
+}
+
+// builderOnBuilding is construct and repair, which differ only in what
+// state the building has to be in.
+func builderOnBuilding(key string, form string, args []string) (string, any, error) {
+	if len(args) != 2 {
+		return "", nil, usage(form)
+	}
+	id, err := entityArg(args[0])
+	if err != nil {
+		return "", nil, err
+	}
+	building, err := entityArg(args[1])
+	if err != nil {
+		return "", nil, err
+	}
+	return key, sim.BuildCommand{ID: id, BuildingID: building}, nil
+}
+
+func usage(form string) error {
+	return fmt.Errorf("usage: %v", form)
+}
+
+func entityArg(arg string) (sim.EntityID, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an id", arg)
+	}
+	return sim.EntityID(id), nil
+}
+
+func float3Arg(x, y, z string) (sim.Float3, error) {
+	var pos sim.Float3
+	for _, coord := range []struct {
+		arg string
+		to  *float64
+	}{{x, &pos.X}, {y, &pos.Y}, {z, &pos.Z}} {
+		value, err := strconv.ParseFloat(coord.arg, 64)
+		if err != nil {
+			return pos, fmt.Errorf("%q is not a coordinate", coord.arg)
+		}
+		*coord.to = value
+	}
+	return pos, nil
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"hackcu2025/sim"
 )
 
 var upgrader = websocket.Upgrader{
@@ -28,6 +33,8 @@ type Command struct {
 	Message string
 	cond *sync.Cond
 	mutex *sync.Mutex
+	// Who sent it, as the simulation knows them.
+	player sim.PlayerID
 }
 
 func makeCommand(command string) *Command {
@@ -42,6 +49,17 @@ func makeCommand(command string) *Command {
 	return cmd
 }
 
+// finish records how the command went and wakes whoever is waiting on it.
+func (c *Command) finish(serviced int, message string) {
+	c.mutex.Lock()
+	if c.Serviced == -1 {
+		c.Serviced = serviced
+		c.Message = message
+		c.cond.Broadcast()
+	}
+	c.mutex.Unlock()
+}
+
 func (c *Command) getCommandString() string {
 	return c.Operation + " " + strings.Join(c.Args, " ")
 } 
@@ -49,12 +67,32 @@ func (c *Command) getCommandString() string {
 type CommandQueue struct {
 	Commands []*Command
 	Mutex *sync.Mutex
+	// Set once the game loop has stopped taking commands.
+	closed bool
 }
 
+// addCommand queues a command for the next tick, or fails it straight away
+// if the game is over and nothing will get to it.
 func (cq *CommandQueue) addCommand(command *Command) {
 	cq.Mutex.Lock()
+	defer cq.Mutex.Unlock()
+	if cq.closed {
+		command.finish(1, "The game is over")
+		return
+	}
 	cq.Commands = append(cq.Commands, command)
+}
+
+// close fails whatever is still queued, and everything queued from now on.
+func (cq *CommandQueue) close() {
+	cq.Mutex.Lock()
+	cq.closed = true
+	left := cq.Commands
+	cq.Commands = nil
 	cq.Mutex.Unlock()
+	for _, command := range left {
+		command.finish(1, "The game is over")
+	}
 }
 
 func (cq *CommandQueue) getCommand() *Command {
@@ -75,37 +113,144 @@ type Game struct {
 	Commands CommandQueue 
 	Running bool
 	Winner *Player
+	// Map is the name of the map the lobby picked, or empty for a
+	// generated one.
+	Map string
+	// Spectators who joined after the game started. Spectators from the
+	// lobby are in GameConnections with no player.
+	spectators []GamePlayerPair
+
+	// The game itself, which only the game loop steps. simMutex guards it
+	// against players reading the state in between.
+	sim *sim.Simulation
+	simMutex sync.Mutex
+	// How the game ended, once it has.
+	result *sim.GameOver
+	// A delayed copy of the game for spectators to watch, or nil if they
+	// watch live. Guarded by simMutex.
+	spectatorFeed *sim.Delayed
 } 
 
-const TICK_MICROS = 20000 
+// TICK_MICROS is how often the game loop steps the simulation.
+const TICK_MICROS = 1000000 / sim.TickRate
+
+// gameCatalog holds the unit and building definitions every game is played
+// with.
+var gameCatalog *sim.Catalog
 
 func (g *Game) handleGameLoop() {
 		log.Printf("Game %v is running", g.Id)
 	
 	for g.Running {
 		lastTick := time.Now().Local().UnixMicro()
-		for len(g.Commands.Commands) > 0 {
-			dt := time.Now().Local().UnixMicro() - lastTick	
-			if dt >= TICK_MICROS {
-				break
+		// Everything that came in since the last tick is applied at the
+		// start of this one. Commands that don't even parse fail straight
+		// away; the rest pass or fail when the simulation gets to them.
+		// queued follows the simulation's queue one for one, so rejections
+		// can be matched up by index. The server's own resigns for
+		// abandoned seats have nobody to answer, so they're nil.
+		queued := make([]*Command, 0)
+		abandoned := g.abandonedSeats()
+		g.simMutex.Lock()
+		for _, pid := range abandoned {
+			if err := g.sim.Queue(pid, "resign", json.RawMessage("{}")); err != nil {
+				log.Printf("Can't resign player %v from game %v: %v", pid, g.Id, err)
+				continue
+			}
+			queued = append(queued, nil)
+		}
+		for command := g.Commands.getCommand(); command != nil; command = g.Commands.getCommand() {
+			key, args, err := command.simCommand()
+			if err == nil {
+				err = g.sim.Queue(command.player, key, args)
+			}
+			if err != nil {
+				command.finish(1, err.Error())
+				continue
+			}
+			queued = append(queued, command)
+		}
+		rejected := g.sim.Step()
+		result := g.sim.Result()
+		if g.spectatorFeed != nil {
+			if err := g.spectatorFeed.CatchUp(); err != nil {
+				log.Printf("Spectator feed of game %v: %v", g.Id, err)
 			}
-			command := g.Commands.getCommand()
+		}
+		g.simMutex.Unlock()
+
+		failed := make(map[int]error)
+		for _, r := range rejected {
+			failed[r.Index] = r.Err
+		}
+		for i, command := range queued {
 			if command == nil {
-				break
+				continue
 			}
-			command.mutex.Lock()
-			if command.Serviced == -1 {
-				command.cond.Broadcast()
-				command.Serviced = 0
-				command.Message = "Command serviced"
+			if err, ok := failed[i]; ok {
+				command.finish(1, err.Error())
+			} else {
+				command.finish(0, "Command serviced")
 			}
-			command.mutex.Unlock()
+		}
+		if result != nil {
+			g.endGame(result)
+			break
 		}
 		dt := time.Now().Local().UnixMicro() - lastTick;
 		time.Sleep(time.Duration(TICK_MICROS - dt) * time.Microsecond)
-		tick_dt := time.Now().Local().UnixMicro() - lastTick;
-		log.Printf("Game %v tick (%v us)", g.Id, tick_dt)	
 	}
+
+	// Nobody's left to get to whatever came in after the end.
+	g.Commands.close()
+}
+
+// abandonedSeats finds the players whose seats have been empty for longer
+// than seatGracePeriod and gives their seats up, so they can be resigned.
+// Each seat is only given up once.
+func (g *Game) abandonedSeats() []sim.PlayerID {
+	sgl.Lock()
+	defer sgl.Unlock()
+	var abandoned []sim.PlayerID
+	for _, gpPair := range GameConnections {
+		ipws := gpPair.ipws
+		if gpPair.game != g || gpPair.player == nil || ipws.Ws != nil || ipws.dropped.IsZero() || ipws.abandoned {
+			continue
+		}
+		if time.Since(ipws.dropped) > seatGracePeriod {
+			log.Printf("Player %v gave up their seat in game %v", ipws.Name, g.Id)
+			ipws.abandoned = true
+			abandoned = append(abandoned, gpPair.player.Id)
+		}
+	}
+	return abandoned
+}
+
+// stateFor is the game as player can see it, or all of it for spectators,
+// as of the spectator delay.
+func (g *Game) stateFor(player *Player) sim.GameState {
+	g.simMutex.Lock()
+	defer g.simMutex.Unlock()
+	if player == nil && g.spectatorFeed != nil {
+		return g.spectatorFeed.Game().GetState()
+	}
+	full := g.sim.Game().GetState()
+	if player == nil {
+		return full
+	}
+	return g.sim.Game().StateFor(full, player.Id)
+}
+
+// endGame stops the game once the simulation says someone's won, or that
+// it's a draw.
+func (g *Game) endGame(result *sim.GameOver) {
+	g.result = result
+	for i := range g.Players {
+		if g.Players[i].Id == result.Winner {
+			g.Winner = &g.Players[i]
+		}
+	}
+	g.stopGame()
 }
 
 func (g *Game) stopGame() {
@@ -115,7 +260,51 @@ func (g *Game) stopGame() {
 	} else {
 		log.Printf("Game %v stopped. No winner", g.Id)
 	}
-	// TODO: Notify players about game stop
+
+	// Hand the players' addresses back so they can join another lobby, and
+	// tell each of them how the game ended.
+	sgl.Lock()
+	players := make([]GamePlayerPair, 0)
+	for id, gpPair := range GameConnections {
+		if gpPair.game == g {
+			players = append(players, gpPair)
+			delete(GameConnections, id)
+		}
+	}
+	players = append(players, g.spectators...)
+	g.spectators = nil
+	delete(GameList, PortNumber(g.Id))
+	sgl.Unlock()
+
+	gameOver := map[string]any{"winner": nil}
+	if g.Winner != nil {
+		gameOver["winner"] = g.Winner.Name
+	}
+	if g.result != nil {
+		// Team games have no single winner, so name everyone on the
+		// winning side.
+		winners := make([]string, 0)
+		for i := range g.Players {
+			for _, pid := range g.result.Winners {
+				if g.Players[i].Id == pid {
+					winners = append(winners, g.Players[i].Name)
+				}
+			}
+		}
+		gameOver["winners"] = winners
+		gameOver["reason"] = g.result.Reason
+		// Names needn't be unique, so the stats go by player number,
+		// with everyone's name alongside.
+		names := make(map[sim.PlayerID]string)
+		for i := range g.Players {
+			names[g.Players[i].Id] = g.Players[i].Name
+		}
+		gameOver["players"] = names
+		gameOver["stats"] = g.result.Stats
+	}
+	for _, gpPair := range players {
+		gpPair.sendMessage("gameOver", gameOver)
+	}
 }	
 
 func (g *GameNetwork) handleGameLoop() {
@@ -123,12 +312,14 @@ func (g *GameNetwork) handleGameLoop() {
 }
 
 
+// A Player is someone in a game: the name they joined the lobby with and
+// who they are in the simulation, which keeps everything else about them,
+// their team included.
 type Player struct {
-	Number int
+	Id sim.PlayerID
 	Name string
 }
 
-type IpAddress string
 type PortNumber int 
 type GameCode string
 
@@ -143,32 +334,75 @@ type GameNetwork struct {
 	ipws []IpWsPair
 }
 
+// An IpWsPair is one lobby member and their connection, named for when
+// members were told apart by address. Their name comes from their
+// Identity.
 type IpWsPair struct {
-	Ip IpAddress
+	Identity
+	Token SessionToken
 	Ws *websocket.Conn
-	Name string
+	Team int
+	// Spectators watch without a seat, so they don't count toward
+	// maxLobbyPlayers.
+	Spectator bool
+	// When the game connection dropped, if it's down.
+	dropped time.Time
+	// Set once the seat has been empty too long and the player has been
+	// resigned from the game.
+	abandoned bool
+	// Held while writing to Ws. Shared by every copy of the pair.
+	writeMutex *sync.Mutex
 }
 
-func (gp GamePlayerPair) sendMessage(messageType string, data interface{}) {
-	if gp.ipws.Ws == nil {
-		log.Printf("WebSocket connection is nil for IP: %v", gp.ipws.Ip)
-		return
+func newIpWsPair(identity Identity, token SessionToken, ws *websocket.Conn, team int, spectator bool) IpWsPair {
+	return IpWsPair{Identity: identity, Token: token, Ws: ws, Team: team, Spectator: spectator, writeMutex: &sync.Mutex{}}
+}
+
+var errNotConnected = errors.New("not connected")
+
+// send writes a message to the member's connection. Gorilla allows only one
+// writer per connection at a time, and a member hears from their own
+// handler, from other members' handlers and from the game loop, so writes
+// take turns. Ws changes when the member reconnects, so it's read under
+// sgl, which the caller mustn't hold.
+func (p *IpWsPair) send(message any) error {
+	sgl.Lock()
+	ws := p.Ws
+	sgl.Unlock()
+	if ws == nil {
+		return errNotConnected
 	}
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	return ws.WriteJSON(message)
+}
+
+// seatGracePeriod is how long a player who drops out of a game has to come
+// back with their token. After that they resign, and their units and
+// buildings stay where they were left.
+const seatGracePeriod = 60 * time.Second
+
+// maxLobbyPlayers is the most players a game can hold.
+const maxLobbyPlayers = 8
+
+// maxSpectatorDelay is the longest a game can hold spectators back, in
+// seconds.
+const maxSpectatorDelay = 300
+
+func (gp GamePlayerPair) sendMessage(messageType string, data interface{}) {
 	messageMap := map[string]interface{}{
 		"messageType": messageType,
 		"data":        data,
 	}
-	err := gp.ipws.Ws.WriteJSON(messageMap)
-	if err != nil {
+	err := gp.ipws.send(messageMap)
+	if err == errNotConnected {
+		log.Printf("WebSocket connection is nil for player: %v", gp.ipws.Name)
+	} else if err != nil {
 		log.Printf("Error sending message: %v", err)
 	}
 }
 
 func (gp GamePlayerPair) sendCommandResponse(command *Command) {
-	if gp.ipws.Ws == nil {
-		log.Printf("WebSocket connection is nil for IP: %v", gp.ipws.Ip)
-		return
-	}
 	response := map[string]interface{}{
 		"messageType": "commandResponse",
 		"data": map[string]interface{}{
@@ -177,15 +411,17 @@ func (gp GamePlayerPair) sendCommandResponse(command *Command) {
 			"command":  command.getCommandString(),
 		},
 	}
-	err := gp.ipws.Ws.WriteJSON(response)
-	if err != nil {
+	err := gp.ipws.send(response)
+	if err == errNotConnected {
+		log.Printf("WebSocket connection is nil for player: %v", gp.ipws.Name)
+	} else if err != nil {
 		log.Printf("Error sending command response: %v", err)
 	}
 }
 
 var Lobbies =  make(map[GameCode][]IpWsPair)
 
-var GameConnections = make(map[IpAddress]GamePlayerPair)   
+var GameConnections = make(map[PlayerId]GamePlayerPair)   
 
 var GameList = make(map[PortNumber]*GameNetwork)
 
@@ -205,8 +441,10 @@ func initGame()*Game {
 	return game
 }
 
+// createPlayer seats the next player from the lobby at the next base on the
+// map.
 func (g *Game)createPlayer(name string) *Player{
-	player := Player{len(g.Players), name}
+	player := Player{g.sim.Game().Map().Bases[len(g.Players)].Player, name}
 	g.Players = append(g.Players, player)
 	return &player
 }
@@ -219,26 +457,67 @@ func handleConnectToGame(portNumber PortNumber) http.HandlerFunc{
 			log.Fatalf("Failed to upgrade to websocket: %v", err)
 		}
 
-		ip := IpAddress(strings.Split(ws.RemoteAddr().String(), ":")[0])
+		identity, signed := verifyToken(SessionToken(r.URL.Query().Get("token")))
 
 		defer ws.Close()
 		var player *Player
 
 		sgl.Lock()
-		gpPair, exists := GameConnections[ip]
-		log.Printf("GameConnections: %v", GameConnections)
+		gpPair, exists := GameConnections[identity.Id]
+		exists = exists && signed
+		if exists && gpPair.game.Id != int(portNumber) {
+			exists = false
+		}
+		if exists && gpPair.ipws.abandoned {
+			log.Printf("Player %v came back to game %v after their seat was given up", gpPair.ipws.Name, portNumber)
+			exists = false
+		}
+		if gameNetwork, ok := GameList[portNumber]; ok && r.URL.Query().Get("spectate") == "true" {
+			// Anyone can watch a running game, as many at a time as they like.
+			watcher := newIpWsPair(Identity{}, "", nil, 0, true)
+			gpPair = GamePlayerPair{gameNetwork.game, nil, &watcher}
+			gameNetwork.game.spectators = append(gameNetwork.game.spectators, gpPair)
+			exists = true
+		}
 		if !exists {
-			log.Printf("No existing connection found for IP: %v", ip)
+			log.Printf("No seat in game %v for session of player %q", portNumber, identity.Name)
 			ws.Close()
 			sgl.Unlock()
 			return
 		}else{
 			player = gpPair.player
+			// A newer connection takes the seat over from an older one
+			// that hasn't noticed it's gone yet.
+			if gpPair.ipws.Ws != nil {
+				gpPair.ipws.Ws.Close()
+			}
 			gpPair.ipws.Ws = ws
+			gpPair.ipws.dropped = time.Time{}
 		}
 		sgl.Unlock()
-		playerNumber := map[string]int{"playerNumber": player.Number}	
-		gpPair.sendMessage("playerNumber", playerNumber)
+		// However the connection ends, hold the seat for the player to
+		// come back to. Spectators who came in off the street have no seat
+		// to hold.
+		defer func() {
+			sgl.Lock()
+			if gpPair.ipws.Ws == ws {
+				gpPair.ipws.Ws = nil
+				gpPair.ipws.dropped = time.Now()
+			}
+			gpPair.game.spectators = slices.DeleteFunc(gpPair.game.spectators, func(s GamePlayerPair) bool {
+				return s.ipws == gpPair.ipws
+			})
+			sgl.Unlock()
+		}()
+		if player == nil {
+			gpPair.sendMessage("spectator", map[string]bool{"spectator": true})
+		} else {
+			playerNumber := map[string]sim.PlayerID{"playerNumber": player.Id}	
+			gpPair.sendMessage("playerNumber", playerNumber)
+		}
+		// Whether this is the first connection or a resumed one, the
+		// client starts from the whole game.
+		gpPair.sendMessage("gameState", gpPair.game.stateFor(player))
 		for {
 			var message map[string]any
 			err := ws.ReadJSON(&message)
@@ -247,9 +526,40 @@ func handleConnectToGame(portNumber PortNumber) http.HandlerFunc{
 				return
 			}
 			log.Printf("Received message: %v", message)
+			if player == nil && (message["messageType"] == "command" || message["stop"] != nil) {
+				// Spectators only get to look.
+				refused := makeCommand("stop")
+				if data, ok := message["data"].(map[string]any); ok {
+					if text, ok := data["command"].(string); ok {
+						refused = makeCommand(text)
+					}
+				}
+				refused.Serviced = 1
+				refused.Message = "Spectators can't send commands"
+				gpPair.sendCommandResponse(refused)
+				continue
+			}
+			// Stopping is resigning. Everyone else plays on, and the game
+			// only ends if that leaves one side standing.
+			text, isCommand := "", false
+			if message["stop"] != nil {
+				text, isCommand = "resign", true
+			}
 			if message["messageType"] == "command"{
-				data := message["data"].(map[string]any)
-				command := makeCommand(data["command"].(string))
+				data, _ := message["data"].(map[string]any)
+				var ok bool
+				text, ok = data["command"].(string)
+				if !ok {
+					malformed := makeCommand("")
+					malformed.finish(1, "Malformed command: data.command should be the command's text")
+					gpPair.sendCommandResponse(malformed)
+					continue
+				}
+				isCommand = true
+			}
+			if isCommand {
+				command := makeCommand(text)
+				command.player = player.Id
 				gpPair.game.Commands.addCommand(command) 
 				command.mutex.Lock()
 				for command.Serviced == -1 {
@@ -258,19 +568,57 @@ func handleConnectToGame(portNumber PortNumber) http.HandlerFunc{
 				command.mutex.Unlock()
 				gpPair.sendCommandResponse(command)
 			}
-			if message["stop"] != nil {
-				gpPair.game.stopGame()
-				break
-			}
 			if message["gameState"] != nil {
-				gpPair.sendMessage("gameState", gpPair.game)
+				gpPair.sendMessage("gameState", gpPair.game.stateFor(player))
 			}
 		}
 	}
 }
 
-func startGame(portNumber PortNumber) {
+// startingMap picks the map for a lobby's game: the map file it named, or
+// a generated map with a base for each player. The teams players picked in
+// the lobby go on the map, unless nobody picked one, which leaves a map
+// file's own teams in place.
+func startingMap(mapName string, ipWsPairs []IpWsPair) (*sim.Map, error) {
+	teams := make([]int, 0)
+	picked := false
+	for _, pair := range ipWsPairs {
+		if !pair.Spectator {
+			teams = append(teams, pair.Team)
+			picked = picked || pair.Team != 0
+		}
+	}
+	choice := sim.MapChoice{Name: mapName, Options: sim.DefaultMapOptions()}
+	choice.Options.Seed = time.Now().UnixNano()
+	// Every map has room for two at least. A base nobody plays just sits
+	// there.
+	choice.Options.Players = max(len(teams), 2)
+	gameMap, err := sim.StartingMap(choice, "", gameCatalog)
+	if err != nil {
+		return nil, err
+	}
+	if len(teams) > len(gameMap.Bases) {
+		return nil, fmt.Errorf("%v only has room for %v players", gameMap.Name, len(gameMap.Bases))
+	}
+	if picked {
+		for i, team := range teams {
+			gameMap.Bases[i].Team = team
+		}
+	}
+	return gameMap, nil
+}
+
+func startGame(portNumber PortNumber, mapName string, gameMap *sim.Map, spectatorDelay float64) error {
 	game := initGame()
+	game.Map = mapName
+	game.sim = sim.NewSimulation(sim.NewGame(time.Now().UnixNano(), gameCatalog, gameMap, sim.DefaultVictoryConditions()))
+	if spectatorDelay > 0 {
+		feed, err := sim.NewDelayed(game.sim, int(spectatorDelay*sim.TickRate))
+		if err != nil {
+			return err
+		}
+		game.spectatorFeed = feed
+	}
 	gameNetwork := &GameNetwork{}
 	gameNetwork.game = game
 	gameNetwork.ipws = make([]IpWsPair, 0)
@@ -280,6 +628,7 @@ func startGame(portNumber PortNumber) {
 	go game.handleGameLoop()
 	slashPort := fmt.Sprintf("/%v", portNumber)
 	http.HandleFunc(slashPort, handleConnectToGame(portNumber))
+	return nil
 }
 
 func buildHeader(w *http.ResponseWriter) {
@@ -295,54 +644,115 @@ func buildHeader(w *http.ResponseWriter) {
 
 func broadcastLobby(ipWsPairs []IpWsPair){
 	names := make([]string, 0)
+	teams := make([]int, 0)
+	spectators := make([]bool, 0)
 	for _, pair := range ipWsPairs {
 		names = append(names, pair.Name)
+		teams = append(teams, pair.Team)
+		spectators = append(spectators, pair.Spectator)
 	}
-	for _, pair := range ipWsPairs {
+	for i := range ipWsPairs {
 		namesMap := make(map[string]any)
 		namesMap["names"] = names
-		pair.Ws.WriteJSON(namesMap)
+		namesMap["teams"] = teams
+		namesMap["spectators"] = spectators
+		ipWsPairs[i].send(namesMap)
 	}
 }
 
-func broadcastStart(ipWsPairs []IpWsPair){
+// A LobbyMessage is what a lobby member sends before the game starts:
+// either a team to switch to, or the signal to start. Map names a map file;
+// leaving it out gets a generated map. SpectatorDelay is how many seconds
+// behind live play spectators watch, so they can't call out what they see
+// to a player.
+type LobbyMessage struct {
+	Start bool `json:"start"`
+	Map string `json:"map"`
+	Team *int `json:"team"`
+	SpectatorDelay float64 `json:"spectatorDelay"`
+}
+
+// broadcastStart starts the lobby's game and sends everyone to it. If the
+// game can't start, everyone is told why and the lobby stays open.
+func broadcastStart(ipWsPairs []IpWsPair, mapName string, spectatorDelay float64) bool {
+	gameMap, err := startingMap(mapName, ipWsPairs)
+	if err == nil && (spectatorDelay < 0 || spectatorDelay > maxSpectatorDelay) {
+		err = fmt.Errorf("spectator delay must be 0 to %v seconds", maxSpectatorDelay)
+	}
+	if err != nil {
+		refuseStart(ipWsPairs, err)
+		return false
+	}
+
 	sgl.Lock()
 	portNumber := PortNumber(startPort+gameNumber+1)
 	if _, exists := GameList[portNumber]; exists {
 		sgl.Unlock()
-		return;
+		return false;
 	}
 	gameNumber++
 
 	log.Printf("Starting game on port %v", portNumber)
-	res := make(map[string]any)
-	res["portNumber"] = portNumber
-	res["start"] = true
-	
-	startGame(portNumber)
+	if err := startGame(portNumber, mapName, gameMap, spectatorDelay); err != nil {
+		sgl.Unlock()
+		refuseStart(ipWsPairs, err)
+		return false
+	}
 	for _, pair := range ipWsPairs {
 		gameNetwork := GameList[portNumber]	
-		player := gameNetwork.game.createPlayer(pair.Name)	
-		GameConnections[pair.Ip] = GamePlayerPair{GameList[portNumber].game, player, &pair}
-		pair.Ws.WriteJSON(res)
+		var player *Player
+		if !pair.Spectator {
+			player = gameNetwork.game.createPlayer(pair.Name)	
+		}
+		// The game's copy waits for the game connection.
 		pair.Ws = nil
+		GameConnections[pair.Id] = GamePlayerPair{GameList[portNumber].game, player, &pair}
 	}
 	sgl.Unlock()
+
+	for i := range ipWsPairs {
+		res := make(map[string]any)
+		res["portNumber"] = portNumber
+		res["start"] = true
+		res["map"] = mapName
+		res["token"] = ipWsPairs[i].Token
+		ipWsPairs[i].send(res)
+	}
+	return true
+}
+
+// refuseStart tells the lobby why its game couldn't start.
+func refuseStart(ipWsPairs []IpWsPair, err error) {
+	log.Printf("Can't start game: %v", err)
+	for i := range ipWsPairs {
+		ipWsPairs[i].send(map[string]string{"error": err.Error()})
+	}
 }
 
-func listenForStart(ws *websocket.Conn, gameCode GameCode){
+func listenForStart(ws *websocket.Conn, gameCode GameCode, id PlayerId){
 	for {
-		var start map[string]bool
+		var start LobbyMessage
 		err := ws.ReadJSON(&start)
 		if err != nil {
 			log.Printf("Error reading JSON: %v", err)
 			return
 		}
-		if start["start"] {
-			broadcastStart(Lobbies[gameCode])
-			delete(Lobbies, gameCode)
+		if start.Team != nil && *start.Team >= 0 && *start.Team <= maxLobbyPlayers {
+			sgl.Lock()
+			for i, pair := range Lobbies[gameCode] {
+				if pair.Id == id {
+					Lobbies[gameCode][i].Team = *start.Team
+				}
+			}
+			sgl.Unlock()
+			broadcastLobby(Lobbies[gameCode])
+		}
+		if start.Start {
 			log.Printf("Received start signal: %v", start)
-			break
+			if broadcastStart(Lobbies[gameCode], start.Map, start.SpectatorDelay) {
+				delete(Lobbies, gameCode)
+				break
+			}
 		}
 	}
 }
@@ -356,36 +766,69 @@ func handleJoinLobby(w http.ResponseWriter, r *http.Request){
 		
 	defer ws.Close()	
 
-	ip := IpAddress(strings.Split(ws.RemoteAddr().String(), ":")[0])
 	gameCode := GameCode(r.URL.Query()["gameCode"][0])
 	name := r.URL.Query()["name"][0]
+	// Players pick a team by joining with ?team=n, or switch later with a
+	// {"team": n} message.
+	team, _ := strconv.Atoi(r.URL.Query().Get("team"))
+	if team < 0 || team > maxLobbyPlayers {
+		team = 0
+	}
+	spectate := r.URL.Query().Get("spectate") == "true"
+	// Members rejoining the lobby, say after a page reload, come back with
+	// the token they were given the first time, and keep the name in it.
+	// Anyone without a good token is someone new.
+	token := SessionToken(r.URL.Query().Get("token"))
+	identity, signed := verifyToken(token)
+	if !signed {
+		identity = newIdentity(name)
+		token = issueToken(identity)
+	}
 
+	// Nobody else can write to ws until it's in the lobby.
+	ws.WriteJSON(map[string]SessionToken{"token": token})
 
 	sgl.Lock()
 	v, e := Lobbies[gameCode]	
 	alreadyJoined := false
 
 	if !e {
-		Lobbies[gameCode] = []IpWsPair{{ip, ws, name}}
+		Lobbies[gameCode] = []IpWsPair{newIpWsPair(identity, token, ws, team, spectate)}
 	}else{
+		seats := 0
 		for i, pair := range v {
-			if pair.Ip == ip {
-				Lobbies[gameCode][i].Name = name
+			if pair.Id == identity.Id {
 				Lobbies[gameCode][i].Ws = ws
+				Lobbies[gameCode][i].Team = team
+				Lobbies[gameCode][i].Spectator = spectate
 				alreadyJoined = true
 			}
+			if !pair.Spectator {
+				seats++
+			}
 		}
 		if !alreadyJoined {
-			Lobbies[gameCode] = append(Lobbies[gameCode], IpWsPair{ip, ws, name})
+			if !spectate && seats >= maxLobbyPlayers {
+				sgl.Unlock()
+				log.Printf("Lobby %v is full", gameCode)
+				return
+			}
+			Lobbies[gameCode] = append(Lobbies[gameCode], newIpWsPair(identity, token, ws, team, spectate))
 		}
 	}
 	sgl.Unlock()
 
 	broadcastLobby(Lobbies[gameCode])
-	listenForStart(ws, gameCode)
+	listenForStart(ws, gameCode, identity.Id)
 }
 
 func main() {
+	var err error
+	gameCatalog, err = sim.LoadCatalog("")
+	if err != nil {
+		log.Fatalf("Failed to load catalog: %v", err)
+	}
+
 	// http.HandleFunc("/play", handlePlay)
 	http.HandleFunc("/join", handleJoinLobby)
 
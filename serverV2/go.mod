@@ -2,4 +2,10 @@ module rts.io
 
 go 1.24.0
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require (
+	github.com/gorilla/websocket v1.5.3
+	hackcu2025/sim v0.0.0
+)
+
+// The simulation lives with the original server.
+replace hackcu2025/sim => ../server/sim
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"os"
+	"strings"
+)
+
+// A PlayerId is who a player is, from joining a lobby to the end of their
+// game. Two tabs or two people behind one address are two players.
+type PlayerId string
+
+// An Identity is what a session token vouches for: the player, and the
+// name they joined the lobby under.
+type Identity struct {
+	Id   PlayerId
+	Name string
+}
+
+// A SessionToken is handed to each lobby member when they join. It's who
+// they are from then on: they present it to rejoin the lobby and to take
+// their seat in the game, including after a dropped connection. Tokens are
+// signed, so a client can't make one up for someone else or change the
+// name in theirs.
+type SessionToken string
+
+// sessionSecret signs tokens. It comes from SESSION_SECRET, so tokens
+// outlive a restart, or else is made up at startup.
+var sessionSecret = loadSessionSecret()
+
+func loadSessionSecret() []byte {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+func newIdentity(name string) Identity {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return Identity{PlayerId(hex.EncodeToString(b)), name}
+}
+
+func signToken(payload string) string {
+	mac := hmac.New(sha256.New, sessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issueToken makes the token for an identity: its id, its name and a
+// signature over both.
+func issueToken(identity Identity) SessionToken {
+	payload := string(identity.Id) + "." + base64.RawURLEncoding.EncodeToString([]byte(identity.Name))
+	return SessionToken(payload + "." + signToken(payload))
+}
+
+// verifyToken returns the identity a token vouches for, if it's one we
+// signed.
+func verifyToken(token SessionToken) (Identity, bool) {
+	parts := strings.Split(string(token), ".")
+	if len(parts) != 3 {
+		return Identity{}, false
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(signToken(payload))) {
+		log.Printf("Rejected session token with a bad signature")
+		return Identity{}, false
+	}
+	name, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || parts[0] == "" {
+		return Identity{}, false
+	}
+	return Identity{PlayerId(parts[0]), string(name)}, true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestVerifyTokenAcceptsIssuedTokens(t *testing.T) {
+	identity := newIdentity("Ada")
+	got, ok := verifyToken(issueToken(identity))
+	if !ok || got != identity {
+		t.Fatalf("verifyToken(issueToken(%+v)) = %+v, %v", identity, got, ok)
+	}
+}
+
+func TestVerifyTokenRefusesTamperedTokens(t *testing.T) {
+	identity := newIdentity("Ada")
+	parts := strings.Split(string(issueToken(identity)), ".")
+	renamed := base64.RawURLEncoding.EncodeToString([]byte("Mallory"))
+	other := strings.Split(string(issueToken(newIdentity("Mallory"))), ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"changed name", parts[0] + "." + renamed + "." + parts[2]},
+		{"changed id", other[0] + "." + parts[1] + "." + parts[2]},
+		{"someone else's signature", parts[0] + "." + parts[1] + "." + other[2]},
+		{"bad signature", parts[0] + "." + parts[1] + ".c2lnbmF0dXJl"},
+		{"no signature", parts[0] + "." + parts[1]},
+		{"empty", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, ok := verifyToken(SessionToken(test.token)); ok {
+				t.Fatalf("verifyToken accepted %q as %+v", test.token, got)
+			}
+		})
+	}
+}